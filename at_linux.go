@@ -0,0 +1,67 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// SendAT sends the AT command "AT"+cmd to a modem and reads the response
+// until a final result code: OK, ERROR, +CME ERROR or +CMS ERROR.  The
+// response lines in between are returned joined by newlines, without the
+// echo of the command or blank lines.  An error result is returned as an
+// error along with the response.  The timeout covers the whole exchange;
+// zero uses Timeout.
+func (s *Serial) SendAT(cmd string, timeout time.Duration) (response string, err error) {
+	line := "AT" + cmd
+	if err := writeAll(s, []byte(line+"\r")); nil != err {
+		return "", err
+	}
+
+	deadline := s.deadlineFor(timeout)
+	var body []string
+	for {
+		wait := time.Duration(0)
+		if !deadline.IsZero() {
+			if wait = time.Until(deadline); wait <= 0 {
+				return strings.Join(body, "\n"), os.ErrDeadlineExceeded
+			}
+		}
+
+		text, err := s.ReadLineAuto(wait)
+		if nil != err {
+			return strings.Join(body, "\n"), err
+		}
+
+		switch {
+		case "OK" == text:
+			return strings.Join(body, "\n"), nil
+		case "ERROR" == text,
+			strings.HasPrefix(text, "+CME ERROR"),
+			strings.HasPrefix(text, "+CMS ERROR"):
+			return strings.Join(body, "\n"), fmt.Errorf("AT command '%s' failed: %s", line, text)
+		case "" == text, line == text:
+			continue
+		}
+
+		body = append(body, text)
+	}
+}
@@ -0,0 +1,94 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Capabilities describes what a port's driver supports, see Capabilities.
+type Capabilities struct {
+	Parities   string // The parity characters the driver accepts, such as "NOE"
+	DataBits   []int  // The numbers of data bits the driver accepts
+	MaxBaud    int    // The highest baud rate, zero if the driver doesn't report it
+	CustomBaud bool   // Baud rates without a Bnnn constant can be set
+	RS485      bool   // The driver has RS-485 support (TIOCGRS485)
+}
+
+// capsCustomBaud is the rate used to probe for custom baud rate support,
+// the MIDI rate, which has no Bnnn constant.
+const capsCustomBaud = 31250
+
+// Capabilities probes the driver of the open port for the settings it
+// supports, so a user interface can offer only those.  Parities, data bits
+// and custom baud rates are probed by applying each setting and checking
+// that the driver kept it, so the line settings change briefly; they are
+// restored afterwards.  MaxBaud comes from MaxBaud and RS485 from probing
+// the RS-485 ioctl.
+func (s *Serial) Capabilities() (Capabilities, error) {
+	var c Capabilities
+
+	orig, err := s.getTermios2()
+	if nil != err {
+		return c, err
+	}
+	defer s.setTermios2(orig)
+
+	// probe applies the Cflag bits and reports if the driver kept them.
+	probe := func(mask, bits uint32) bool {
+		t := orig
+		t.Cflag = t.Cflag&^mask | bits
+		if nil != s.setTermios2(t) {
+			return false
+		}
+		got, err := s.getTermios2()
+
+		return nil == err && bits == got.Cflag&mask
+	}
+
+	parityMask := uint32(unix.PARENB | unix.PARODD | unix.CMSPAR)
+	for i := 0; i < len(parityChars); i++ {
+		if probe(parityMask, parityMap[parityChars[i]]) {
+			c.Parities += parityChars[i : i+1]
+		}
+	}
+
+	for bits := 5; bits <= 8; bits++ {
+		if probe(unix.CSIZE, dataBitsMap[bits]) {
+			c.DataBits = append(c.DataBits, bits)
+		}
+	}
+
+	t := orig
+	if nil == cfsetospeed(&t, capsCustomBaud) && nil == cfsetispeed(&t, capsCustomBaud) && nil == s.setTermios2(t) {
+		got, err := s.getTermios2()
+		c.CustomBaud = nil == err && unix.BOTHER == got.Cflag&unix.CBAUD
+	}
+
+	if baud, err := s.MaxBaud(); nil == err {
+		c.MaxBaud = baud
+	}
+
+	// The kernel's struct serial_rs485 is 32 bytes.
+	var rs485 [32]byte
+	c.RS485 = 0 == s.ioctl(uintptr(unix.TIOCGRS485), uintptr(unsafe.Pointer(&rs485)))
+
+	return c, nil
+}
@@ -0,0 +1,183 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Captures are text files with one record per line:
+//
+//	<seconds> <direction> <hex bytes>
+//
+// seconds is the time since the first record, with microsecond precision,
+// direction is '>' for bytes written to the port and '<' for bytes read
+// from it, and the data is hex encoded.  For example:
+//
+//	0.000000 > 41540d
+//	0.012345 < 0d0a4f4b0d0a
+//
+// Blank lines and lines starting with '#' are ignored, so captures can be
+// annotated by hand.
+
+// Recorder passes reads and writes through to a port while logging every
+// transfer, with its timing, to Log in the capture format.
+type Recorder struct {
+	Port io.ReadWriter // The port being recorded, usually a *Serial
+	Log  io.Writer     // Where the capture is written
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+// Read reads from the port and records the bytes received.
+func (r *Recorder) Read(b []byte) (n int, err error) {
+	n, err = r.Port.Read(b)
+	if 0 < n {
+		if lerr := r.record('<', b[:n]); nil == err {
+			err = lerr
+		}
+	}
+
+	return n, err
+}
+
+// Write writes to the port and records the bytes sent.
+func (r *Recorder) Write(b []byte) (n int, err error) {
+	n, err = r.Port.Write(b)
+	if 0 < n {
+		if lerr := r.record('>', b[:n]); nil == err {
+			err = lerr
+		}
+	}
+
+	return n, err
+}
+
+func (r *Recorder) record(dir byte, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.start.IsZero() {
+		r.start = now
+	}
+
+	_, err := fmt.Fprintf(r.Log, "%.6f %c %x\n", now.Sub(r.start).Seconds(), dir, data)
+
+	return err
+}
+
+// replayRecord is a single block of received bytes from a capture.
+type replayRecord struct {
+	at   time.Duration
+	data []byte
+}
+
+// Replayer plays back the bytes received in a capture with their original
+// timing, so protocol code can be exercised without the hardware.  The
+// clock starts with the first Read or Write.  Writes are accepted and
+// discarded.  Once the capture is exhausted Read returns io.EOF.
+type Replayer struct {
+	records []replayRecord
+	start   time.Time
+}
+
+// NewReplayer reads a capture and returns a Replayer for it.
+func NewReplayer(capture io.Reader) (*Replayer, error) {
+	var r Replayer
+
+	scanner := bufio.NewScanner(capture)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if "" == text || '#' == text[0] {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if 3 != len(fields) {
+			return nil, fmt.Errorf("Invalid capture record on line %d.", line)
+		}
+
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if nil != err {
+			return nil, fmt.Errorf("Invalid capture time on line %d: %w", line, err)
+		}
+
+		data, err := hex.DecodeString(fields[2])
+		if nil != err {
+			return nil, fmt.Errorf("Invalid capture data on line %d: %w", line, err)
+		}
+
+		switch fields[1] {
+		case "<":
+			at := time.Duration(seconds * float64(time.Second))
+			r.records = append(r.records, replayRecord{at: at, data: data})
+		case ">":
+		default:
+			return nil, fmt.Errorf("Invalid capture direction on line %d.", line)
+		}
+	}
+
+	if err := scanner.Err(); nil != err {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Read waits until the next recorded block of bytes is due and returns it.
+// A block larger than b is returned over several calls.
+func (r *Replayer) Read(b []byte) (n int, err error) {
+	if 0 == len(r.records) {
+		return 0, io.EOF
+	}
+
+	r.begin()
+	next := &r.records[0]
+	time.Sleep(time.Until(r.start.Add(next.at)))
+
+	n = copy(b, next.data)
+	next.data = next.data[n:]
+	if 0 == len(next.data) {
+		r.records = r.records[1:]
+	}
+
+	return n, nil
+}
+
+// Write discards the bytes.
+func (r *Replayer) Write(b []byte) (n int, err error) {
+	r.begin()
+
+	return len(b), nil
+}
+
+func (r *Replayer) begin() {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+}
@@ -0,0 +1,142 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"io"
+)
+
+// COBS is a Codec implementing Consistent Overhead Byte Stuffing.  The
+// encoded data never contains a zero byte, so zero delimits the frames.
+type COBS struct{}
+
+// Encode stuffs the payload and appends the zero delimiter.
+func (COBS) Encode(payload []byte) ([]byte, error) {
+	return append(CobsEncode(payload), 0), nil
+}
+
+// Decode removes the stuffing from a received frame.
+func (COBS) Decode(frame []byte) ([]byte, error) {
+	return CobsDecode(frame)
+}
+
+// Delimiter returns the zero byte.
+func (COBS) Delimiter() byte {
+	return 0
+}
+
+// CobsEncode returns the COBS encoding of b.  The result never contains a
+// zero byte and does not include the trailing zero delimiter.
+func CobsEncode(b []byte) []byte {
+	out := make([]byte, 1, len(b)+len(b)/254+2)
+	ci, code := 0, byte(1)
+
+	for i, v := range b {
+		if 0 != v {
+			out = append(out, v)
+			code++
+			if 0xff != code {
+				continue
+			}
+
+			// A full block at the end of the input needs no further block.
+			if i+1 == len(b) {
+				out[ci] = code
+				return out
+			}
+		}
+
+		out[ci] = code
+		ci, code = len(out), 1
+		out = append(out, 0)
+	}
+	out[ci] = code
+
+	return out
+}
+
+// CobsDecode returns the data encoded in the COBS encoded b.  The trailing
+// zero delimiter must not be included.
+func CobsDecode(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+
+	for i := 0; i < len(b); {
+		code := int(b[i])
+		if 0 == code {
+			return nil, fmt.Errorf("Unexpected zero byte in COBS data.")
+		}
+		i++
+
+		if len(b) < i+code-1 {
+			return nil, fmt.Errorf("Truncated COBS data.")
+		}
+		out = append(out, b[i:i+code-1]...)
+		i += code - 1
+
+		if 0xff != code && i < len(b) {
+			out = append(out, 0)
+		}
+	}
+
+	return out, nil
+}
+
+// CobsReader reads whole COBS frames from a port.  Each Read returns the
+// payload of exactly one frame.
+type CobsReader struct {
+	Port io.Reader // The port to read frames from, usually a *Serial
+
+	frames frameReader
+}
+
+// Read reads the next zero delimited frame and copies its decoded payload
+// into b.  Partial frames are buffered between calls.  If the port returns
+// without any data, Read returns as well.  If b is too small for the
+// payload io.ErrShortBuffer is returned and the frame is discarded.
+func (r *CobsReader) Read(b []byte) (n int, err error) {
+	frame, err := r.frames.next(r.Port, 0)
+	if nil != err || nil == frame {
+		return 0, err
+	}
+
+	payload, err := CobsDecode(frame)
+	if nil != err {
+		return 0, err
+	}
+	if len(b) < len(payload) {
+		return 0, io.ErrShortBuffer
+	}
+
+	return copy(b, payload), nil
+}
+
+// CobsWriter writes whole COBS frames to a port.  Each Write sends exactly
+// one frame followed by the zero delimiter.
+type CobsWriter struct {
+	Port io.Writer // The port to write frames to, usually a *Serial
+}
+
+// Write encodes b as a single COBS frame and writes it to the port.
+func (w *CobsWriter) Write(b []byte) (n int, err error) {
+	if err := writeAll(w.Port, append(CobsEncode(b), 0)); nil != err {
+		return 0, err
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,191 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// seq returns the bytes from first to last inclusive.
+func seq(first, last int) []byte {
+	b := make([]byte, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		b = append(b, byte(i))
+	}
+
+	return b
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestCobs(t *testing.T) {
+	tests := []struct {
+		description string
+		data        []byte
+		encoded     []byte
+	}{
+		{
+			description: "empty",
+			data:        []byte{},
+			encoded:     []byte{0x01},
+		}, {
+			description: "single zero",
+			data:        []byte{0x00},
+			encoded:     []byte{0x01, 0x01},
+		}, {
+			description: "two zeros",
+			data:        []byte{0x00, 0x00},
+			encoded:     []byte{0x01, 0x01, 0x01},
+		}, {
+			description: "zero in the middle",
+			data:        []byte{0x11, 0x22, 0x00, 0x33},
+			encoded:     []byte{0x03, 0x11, 0x22, 0x02, 0x33},
+		}, {
+			description: "no zeros",
+			data:        []byte{0x11, 0x22, 0x33, 0x44},
+			encoded:     []byte{0x05, 0x11, 0x22, 0x33, 0x44},
+		}, {
+			description: "trailing zeros",
+			data:        []byte{0x11, 0x00, 0x00, 0x00},
+			encoded:     []byte{0x02, 0x11, 0x01, 0x01, 0x01},
+		}, {
+			description: "run of 254 non-zero bytes",
+			data:        seq(0x01, 0xfe),
+			encoded:     join([]byte{0xff}, seq(0x01, 0xfe)),
+		}, {
+			description: "zero then a run of 254",
+			data:        seq(0x00, 0xfe),
+			encoded:     join([]byte{0x01, 0xff}, seq(0x01, 0xfe)),
+		}, {
+			description: "run of 255 non-zero bytes",
+			data:        seq(0x01, 0xff),
+			encoded:     join([]byte{0xff}, seq(0x01, 0xfe), []byte{0x02, 0xff}),
+		}, {
+			description: "run of 254 then a zero",
+			data:        join(seq(0x02, 0xff), []byte{0x00}),
+			encoded:     join([]byte{0xff}, seq(0x02, 0xff), []byte{0x01, 0x01}),
+		}, {
+			description: "run of 253 then a zero",
+			data:        join(seq(0x03, 0xff), []byte{0x00, 0x01}),
+			encoded:     join([]byte{0xfe}, seq(0x03, 0xff), []byte{0x02, 0x01}),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			encoded := CobsEncode(tc.data)
+			if !bytes.Equal(tc.encoded, encoded) {
+				t.Fatalf("CobsEncode() = % x, expected % x", encoded, tc.encoded)
+			}
+			if 0 <= bytes.IndexByte(encoded, 0) {
+				t.Fatalf("CobsEncode() = % x contains a zero byte", encoded)
+			}
+
+			data, err := CobsDecode(encoded)
+			if nil != err {
+				t.Fatalf("CobsDecode() error: %v", err)
+			}
+			if !bytes.Equal(tc.data, data) {
+				t.Fatalf("CobsDecode() = % x, expected % x", data, tc.data)
+			}
+		})
+	}
+}
+
+func TestCobsRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for size := 0; size < 1024; size++ {
+		data := make([]byte, size)
+		r.Read(data)
+		// Make zeros common enough to exercise the short blocks.
+		for i := range data {
+			if 0 == r.Intn(8) {
+				data[i] = 0
+			}
+		}
+
+		decoded, err := CobsDecode(CobsEncode(data))
+		if nil != err {
+			t.Fatalf("size %d: CobsDecode() error: %v", size, err)
+		}
+		if !bytes.Equal(data, decoded) {
+			t.Fatalf("size %d: round trip = % x, expected % x", size, decoded, data)
+		}
+	}
+}
+
+func TestCobsDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		description string
+		encoded     []byte
+	}{
+		{
+			description: "zero byte",
+			encoded:     []byte{0x02, 0x11, 0x00},
+		}, {
+			description: "truncated block",
+			encoded:     []byte{0x05, 0x11, 0x22},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if _, err := CobsDecode(tc.encoded); nil == err {
+				t.Fatalf("CobsDecode(% x) succeeded, expected an error", tc.encoded)
+			}
+		})
+	}
+}
+
+func TestCobsReaderWriter(t *testing.T) {
+	payloads := [][]byte{
+		{0x00},
+		{0x11, 0x00, 0x22},
+		seq(0x00, 0xff),
+	}
+
+	var buf bytes.Buffer
+	w := &CobsWriter{Port: &buf}
+	for _, p := range payloads {
+		if _, err := w.Write(p); nil != err {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+
+	r := &CobsReader{Port: &buf}
+	b := make([]byte, 512)
+	for _, p := range payloads {
+		n, err := r.Read(b)
+		if nil != err {
+			t.Fatalf("Read() error: %v", err)
+		}
+		if !bytes.Equal(p, b[:n]) {
+			t.Fatalf("Read() = % x, expected % x", b[:n], p)
+		}
+	}
+
+	if _, err := r.Read(b); io.EOF != err {
+		t.Fatalf("Read() at the end = %v, expected io.EOF", err)
+	}
+}
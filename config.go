@@ -0,0 +1,219 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the line settings of a port, the Serial fields UpdateCfg
+// applies, as a value that can be stored and compared.  See GetConfig and
+// Configure.
+type Config struct {
+	Baud             int
+	Framing          string // The Serial Config string, such as '8N1'
+	Canonical        bool
+	Terminal         bool
+	SignalChars      bool
+	OutputProcessing bool
+	Polling          bool
+	Vmin             byte
+	Vtime            time.Duration
+	Break            BreakMode
+	ParityChecking   ParityCheck
+	RTSCTS           bool
+	DisableReceiver  bool
+	Multidrop        bool
+	StopBits1Point5  bool
+	CRToNL           bool
+	IgnoreCR         bool
+	NLToCR           bool
+	EOL              byte
+	EOL2             byte
+}
+
+// GetConfig returns the line settings currently set in the Serial fields.
+func (s *Serial) GetConfig() Config {
+	return Config{
+		Baud:             s.Baud,
+		Framing:          s.Config,
+		Canonical:        s.Canonical,
+		Terminal:         s.Terminal,
+		SignalChars:      s.SignalChars,
+		OutputProcessing: s.OutputProcessing,
+		Polling:          s.Polling,
+		Vmin:             s.Vmin,
+		Vtime:            s.Vtime,
+		Break:            s.Break,
+		ParityChecking:   s.ParityChecking,
+		RTSCTS:           s.RTSCTS,
+		DisableReceiver:  s.DisableReceiver,
+		Multidrop:        s.Multidrop,
+		StopBits1Point5:  s.StopBits1Point5,
+		CRToNL:           s.CRToNL,
+		IgnoreCR:         s.IgnoreCR,
+		NLToCR:           s.NLToCR,
+		EOL:              s.EOL,
+		EOL2:             s.EOL2,
+	}
+}
+
+// setConfig copies the line settings into the Serial fields.
+func (s *Serial) setConfig(c Config) {
+	s.Baud = c.Baud
+	s.Config = c.Framing
+	s.Canonical = c.Canonical
+	s.Terminal = c.Terminal
+	s.SignalChars = c.SignalChars
+	s.OutputProcessing = c.OutputProcessing
+	s.Polling = c.Polling
+	s.Vmin = c.Vmin
+	s.Vtime = c.Vtime
+	s.Break = c.Break
+	s.ParityChecking = c.ParityChecking
+	s.RTSCTS = c.RTSCTS
+	s.DisableReceiver = c.DisableReceiver
+	s.Multidrop = c.Multidrop
+	s.StopBits1Point5 = c.StopBits1Point5
+	s.CRToNL = c.CRToNL
+	s.IgnoreCR = c.IgnoreCR
+	s.NLToCR = c.NLToCR
+	s.EOL = c.EOL
+	s.EOL2 = c.EOL2
+}
+
+// normalized returns the configuration with an empty Framing replaced by
+// DefaultConfig, since both mean the same.
+func (c Config) normalized() Config {
+	if "" == c.Framing {
+		c.Framing = DefaultConfig
+	}
+
+	return c
+}
+
+// Equal reports if both configurations describe the same line settings.
+func (c Config) Equal(other Config) bool {
+	return c.normalized() == other.normalized()
+}
+
+// Diff lists the settings that differ between the configurations, one
+// entry per field in the form "Baud: 9600 != 115200", with the value of c
+// first.  It is empty if the configurations are Equal.
+func (c Config) Diff(other Config) []string {
+	a := reflect.ValueOf(c.normalized())
+	b := reflect.ValueOf(other.normalized())
+
+	var diff []string
+	for i := 0; i < a.NumField(); i++ {
+		x, y := a.Field(i).Interface(), b.Field(i).Interface()
+		if x != y {
+			diff = append(diff, fmt.Sprintf("%s: %v != %v", a.Type().Field(i).Name, x, y))
+		}
+	}
+
+	return diff
+}
+
+var (
+	presetsMu sync.RWMutex
+
+	// presets holds the named configurations for ApplyPreset.
+	presets = map[string]Config{
+		"nmea":    {Baud: 4800, Framing: "8N1"},
+		"modbus":  {Baud: 19200, Framing: "8E1"},
+		"console": {Baud: 115200, Framing: "8N1", Terminal: true},
+		"dmx512":  {Baud: 250000, Framing: "8N2"},
+	}
+)
+
+// RegisterPreset adds or replaces a named configuration for ApplyPreset.
+// The presets "nmea" (4800 8N1), "modbus" (19200 8E1), "console" (115200
+// 8N1 in Terminal mode) and "dmx512" (250000 8N2) are registered by
+// default.
+func RegisterPreset(name string, cfg Config) {
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+
+	presets[name] = cfg
+}
+
+// lookupPreset returns the named configuration.
+func lookupPreset(name string) (Config, error) {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+
+	cfg, ok := presets[name]
+	if !ok {
+		return Config{}, fmt.Errorf("Unknown preset '%s'.", name)
+	}
+
+	return cfg, nil
+}
+
+// ConfigFromEnv reads the port name and configuration from environment
+// variables starting with the prefix and an underscore, for deployments
+// configured through the environment:
+//
+//	<prefix>_PORT   the port name, required
+//	<prefix>_BAUD   the baud rate, required
+//	<prefix>_MODE   the configuration string such as '8N1', DefaultConfig if unset
+//	<prefix>_FLOW   "none", the default, or "rtscts"
+func ConfigFromEnv(prefix string) (name string, cfg Config, err error) {
+	env := func(key string) (string, string) {
+		key = prefix + "_" + key
+		return key, strings.TrimSpace(os.Getenv(key))
+	}
+
+	key, name := env("PORT")
+	if "" == name {
+		return "", Config{}, fmt.Errorf("Environment variable %s is not set.", key)
+	}
+
+	key, baud := env("BAUD")
+	if "" == baud {
+		return "", Config{}, fmt.Errorf("Environment variable %s is not set.", key)
+	}
+	cfg.Baud, err = strconv.Atoi(baud)
+	if nil != err || cfg.Baud < 1 {
+		return "", Config{}, fmt.Errorf("Invalid baud rate '%s' in %s.", baud, key)
+	}
+
+	key, mode := env("MODE")
+	if _, _, _, err := ParseConfig(mode); nil != err {
+		return "", Config{}, fmt.Errorf("Invalid %s: %w", key, err)
+	}
+	cfg.Framing = mode
+
+	key, flow := env("FLOW")
+	switch strings.ToLower(flow) {
+	case "", "none":
+	case "rtscts":
+		cfg.RTSCTS = true
+	default:
+		return "", Config{}, fmt.Errorf("Invalid flow control '%s' in %s, expected none or rtscts.", flow, key)
+	}
+
+	return name, cfg, nil
+}
@@ -0,0 +1,63 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigEqualDiff(t *testing.T) {
+	tests := []struct {
+		description string
+		a, b        Config
+		diff        []string
+	}{
+		{
+			description: "identical",
+			a:           Config{Baud: 9600, Framing: "8N1"},
+			b:           Config{Baud: 9600, Framing: "8N1"},
+		}, {
+			description: "empty framing is the default",
+			a:           Config{Baud: 9600},
+			b:           Config{Baud: 9600, Framing: DefaultConfig},
+		}, {
+			description: "baud and framing",
+			a:           Config{Baud: 9600, Framing: "8N1"},
+			b:           Config{Baud: 115200, Framing: "7E1"},
+			diff:        []string{"Baud: 9600 != 115200", "Framing: 8N1 != 7E1"},
+		}, {
+			description: "flags and durations",
+			a:           Config{RTSCTS: true, Vtime: time.Second},
+			b:           Config{Vtime: 100 * time.Millisecond},
+			diff:        []string{"Vtime: 1s != 100ms", "RTSCTS: true != false"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if equal := tc.a.Equal(tc.b); (0 == len(tc.diff)) != equal {
+				t.Errorf("Equal() = %v", equal)
+			}
+			if diff := tc.a.Diff(tc.b); !reflect.DeepEqual(tc.diff, diff) {
+				t.Errorf("Diff() = %q, expected %q", diff, tc.diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import "net"
+
+// serialAddr is the net.Addr of a serial port, which is its device name.
+type serialAddr string
+
+// Network returns "serial".
+func (a serialAddr) Network() string {
+	return "serial"
+}
+
+// String returns the device name.
+func (a serialAddr) String() string {
+	return string(a)
+}
+
+// LocalAddr returns the address of the serial port, which is its Name.  It
+// exists so *Serial can be used as a net.Conn.
+func (s *Serial) LocalAddr() net.Addr {
+	return serialAddr(s.Name)
+}
+
+// RemoteAddr returns the same address as LocalAddr since a serial link has
+// no separate address for the peer.
+func (s *Serial) RemoteAddr() net.Addr {
+	return serialAddr(s.Name)
+}
@@ -0,0 +1,115 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package crc provides the checksums commonly used by serial protocols.
+//
+// Each algorithm documents its check value: the result of computing it over
+// the ASCII string "123456789".
+package crc
+
+// CRC8 computes the CRC-8 (polynomial 0x07, initial value 0x00, no
+// reflection) used by SMBus and many sensors.  The check value is 0xF4.
+func CRC8(b []byte) uint8 {
+	crc := uint8(0)
+	for _, v := range b {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if 0 != crc&0x80 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// CRC8Maxim computes the Dallas/Maxim CRC-8 (polynomial 0x31 reflected,
+// initial value 0x00) used by 1-Wire devices.  The check value is 0xA1.
+func CRC8Maxim(b []byte) uint8 {
+	crc := uint8(0)
+	for _, v := range b {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if 0 != crc&1 {
+				crc = crc>>1 ^ 0x8c
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// CRC16Modbus computes the Modbus CRC-16 (polynomial 0x8005 reflected,
+// initial value 0xFFFF).  The result is sent low byte first.  The check
+// value is 0x4B37.
+func CRC16Modbus(b []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, v := range b {
+		crc ^= uint16(v)
+		for i := 0; i < 8; i++ {
+			if 0 != crc&1 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// CRC16CCITT computes the CRC-16/CCITT-FALSE (polynomial 0x1021, initial
+// value 0xFFFF, no reflection).  The check value is 0x29B1.
+func CRC16CCITT(b []byte) uint16 {
+	return crc16CCITT(0xffff, b)
+}
+
+// CRC16XModem computes the CRC-16/XMODEM (polynomial 0x1021, initial value
+// 0x0000, no reflection).  The check value is 0x31C3.
+func CRC16XModem(b []byte) uint16 {
+	return crc16CCITT(0, b)
+}
+
+func crc16CCITT(crc uint16, b []byte) uint16 {
+	for _, v := range b {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if 0 != crc&0x8000 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// XOR computes the exclusive or of all the bytes, the simple checksum used
+// by NMEA sentences and many ad hoc protocols.  The check value is 0x31.
+func XOR(b []byte) uint8 {
+	var sum uint8
+	for _, v := range b {
+		sum ^= v
+	}
+
+	return sum
+}
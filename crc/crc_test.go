@@ -0,0 +1,75 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package crc
+
+import "testing"
+
+// check is the input the check values of the algorithms are computed over.
+var check = []byte("123456789")
+
+func TestCheckValues(t *testing.T) {
+	tests := []struct {
+		description string
+		sum         func([]byte) uint64
+		expected    uint64
+	}{
+		{
+			description: "CRC8",
+			sum:         func(b []byte) uint64 { return uint64(CRC8(b)) },
+			expected:    0xf4,
+		}, {
+			description: "CRC8Maxim",
+			sum:         func(b []byte) uint64 { return uint64(CRC8Maxim(b)) },
+			expected:    0xa1,
+		}, {
+			description: "CRC16Modbus",
+			sum:         func(b []byte) uint64 { return uint64(CRC16Modbus(b)) },
+			expected:    0x4b37,
+		}, {
+			description: "CRC16CCITT",
+			sum:         func(b []byte) uint64 { return uint64(CRC16CCITT(b)) },
+			expected:    0x29b1,
+		}, {
+			description: "CRC16XModem",
+			sum:         func(b []byte) uint64 { return uint64(CRC16XModem(b)) },
+			expected:    0x31c3,
+		}, {
+			description: "XOR",
+			sum:         func(b []byte) uint64 { return uint64(XOR(b)) },
+			expected:    0x31,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if got := tc.sum(check); tc.expected != got {
+				t.Fatalf("%s(\"123456789\") = 0x%x, expected 0x%x", tc.description, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestModbusFrame(t *testing.T) {
+	// Read holding registers 0 and 1 of device 1, a frame commonly used as
+	// an example, whose CRC is sent low byte first as 0xc4 0x0b.
+	frame := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x02}
+
+	if got := CRC16Modbus(frame); 0x0bc4 != got {
+		t.Fatalf("CRC16Modbus() = 0x%04x, expected 0x0bc4", got)
+	}
+}
@@ -0,0 +1,201 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrChecksum is returned when a received frame fails its checksum.
+var ErrChecksum = errors.New("Invalid frame checksum.")
+
+// Codec converts payloads to and from the frames sent over a serial link.
+type Codec interface {
+	// Encode returns the frame for the payload, including the trailing
+	// delimiter.
+	Encode(payload []byte) ([]byte, error)
+
+	// Decode returns the payload contained in a frame.  The frame passed
+	// in does not include the trailing delimiter.
+	Decode(frame []byte) ([]byte, error)
+
+	// Delimiter returns the byte that marks the end of a frame.
+	Delimiter() byte
+}
+
+// Delimited is a Codec that wraps each payload between a start and an end
+// byte, for example STX/ETX.  No escaping is done, so the payload may not
+// contain the end byte.
+type Delimited struct {
+	Start byte // The byte that starts a frame
+	End   byte // The byte that ends a frame
+}
+
+// Encode wraps the payload in the start and end bytes.
+func (d Delimited) Encode(payload []byte) ([]byte, error) {
+	if 0 <= bytes.IndexByte(payload, d.End) {
+		return nil, fmt.Errorf("Payload contains the frame end byte 0x%02x.", d.End)
+	}
+
+	frame := make([]byte, 0, len(payload)+2)
+	frame = append(frame, d.Start)
+	frame = append(frame, payload...)
+
+	return append(frame, d.End), nil
+}
+
+// Decode removes the start byte from the frame.  Any noise received before
+// the start byte is discarded.
+func (d Delimited) Decode(frame []byte) ([]byte, error) {
+	i := bytes.IndexByte(frame, d.Start)
+	if i < 0 {
+		return nil, fmt.Errorf("Frame is missing the start byte 0x%02x.", d.Start)
+	}
+
+	return frame[i+1:], nil
+}
+
+// Delimiter returns the end byte.
+func (d Delimited) Delimiter() byte {
+	return d.End
+}
+
+// Framer reads and writes whole frames over a serial port using a Codec.
+// Each Write sends exactly one frame and each Read returns exactly one
+// frame's payload.
+type Framer struct {
+	Port  io.ReadWriter // The port to exchange frames over, usually a *Serial
+	Codec Codec         // How payloads are encoded into frames
+
+	// Checksum, when set, is computed over the payload and appended to it
+	// before encoding.  It must always return the same number of bytes.
+	// Received frames with a bad checksum are reported with ErrChecksum.
+	Checksum func(payload []byte) []byte
+
+	frames frameReader
+}
+
+// Write encodes the payload into a single frame and writes it to the port.
+func (f *Framer) Write(payload []byte) (n int, err error) {
+	data := payload
+	if nil != f.Checksum {
+		data = append(append([]byte{}, payload...), f.Checksum(payload)...)
+	}
+
+	frame, err := f.Codec.Encode(data)
+	if nil != err {
+		return 0, err
+	}
+
+	if err := writeAll(f.Port, frame); nil != err {
+		return 0, err
+	}
+
+	return len(payload), nil
+}
+
+// Read reads the next frame from the port and copies its payload into b.
+// Partial frames are buffered between calls.  If the port returns without
+// any data, Read returns as well so timeouts configured on the port are
+// honored.  If b is too small for the payload io.ErrShortBuffer is returned
+// and the frame is discarded.
+func (f *Framer) Read(b []byte) (n int, err error) {
+	frame, err := f.frames.next(f.Port, f.Codec.Delimiter())
+	if nil != err || nil == frame {
+		return 0, err
+	}
+
+	payload, err := f.decode(frame)
+	if nil != err {
+		return 0, err
+	}
+	if len(b) < len(payload) {
+		return 0, io.ErrShortBuffer
+	}
+
+	return copy(b, payload), nil
+}
+
+func (f *Framer) decode(frame []byte) ([]byte, error) {
+	payload, err := f.Codec.Decode(frame)
+	if nil != err || nil == f.Checksum {
+		return payload, err
+	}
+
+	size := len(f.Checksum(nil))
+	if len(payload) < size {
+		return nil, ErrChecksum
+	}
+
+	data := payload[:len(payload)-size]
+	if !bytes.Equal(f.Checksum(data), payload[len(data):]) {
+		return nil, ErrChecksum
+	}
+
+	return data, nil
+}
+
+// frameReader splits the bytes read from a port into delimited frames,
+// buffering partial frames between calls.
+type frameReader struct {
+	buf []byte
+}
+
+// next returns the next non-empty frame without its delimiter.  A nil frame
+// and nil error are returned when the port returns without any data.
+func (fr *frameReader) next(r io.Reader, delim byte) ([]byte, error) {
+	for {
+		if i := bytes.IndexByte(fr.buf, delim); 0 <= i {
+			frame := fr.buf[:i]
+			fr.buf = fr.buf[i+1:]
+
+			// Back to back delimiters are idle fill, not frames.
+			if 0 == len(frame) {
+				continue
+			}
+
+			return frame, nil
+		}
+
+		tmp := make([]byte, 256)
+		n, err := r.Read(tmp)
+		fr.buf = append(fr.buf, tmp[:n]...)
+		if nil != err {
+			return nil, err
+		}
+		if 0 == n {
+			return nil, nil
+		}
+	}
+}
+
+// writeAll writes all of b, continuing after short writes.
+func writeAll(w io.Writer, b []byte) error {
+	for 0 < len(b) {
+		n, err := w.Write(b)
+		if nil != err {
+			return err
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
@@ -1,5 +1,5 @@
 module github.com/schmidtw/go232
 
-go 1.13
+go 1.21
 
 require golang.org/x/sys v0.0.0-20191206220618-eeba5f6aabab
@@ -0,0 +1,495 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package go232 provides a simple but usable way to interact with devices
+// that have serial ports.
+package go232
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotSupported is returned when the serial driver does not support the
+// requested operation.
+var ErrNotSupported = errors.New("Operation not supported by the serial driver.")
+
+// ErrReconnecting is returned by Read and Write while AutoReconnect is
+// reopening a port whose device went away.  The call can be retried.
+var ErrReconnecting = errors.New("Serial port is reconnecting.")
+
+// ErrBreakReceived is returned by ReadMarked when a break was received on
+// the line.
+var ErrBreakReceived = errors.New("Break received.")
+
+// ErrTimeoutBelowLatency is returned by CheckReadTimeout when a read timeout
+// is shorter than the port's hardware can deliver the data.
+var ErrTimeoutBelowLatency = errors.New("Read timeout is shorter than the latency of the port.")
+
+// DefaultConfig is the configuration used when Config is empty.
+const DefaultConfig = "8N1"
+
+// DefaultMaxPayload is the largest payload ReadLengthPrefixed accepts when
+// MaxPayload is zero.
+const DefaultMaxPayload = 64 * 1024
+
+// configParts names the parts of a configuration string by position.
+var configParts = [...]string{"data bits", "parity", "stop bits"}
+
+// The parity characters accepted in a configuration string: none, odd,
+// even, mark and space.
+const parityChars = "NOEMS"
+
+// TimeoutMode selects how the read timeout set by SetReadTimeout is
+// implemented.
+type TimeoutMode int
+
+const (
+	// TimeoutPoll waits for data by polling the port from user space.  It
+	// gives millisecond precision at the cost of an extra system call per
+	// Read.  This is the default.
+	TimeoutPoll TimeoutMode = iota
+
+	// TimeoutVTIME hands the timeout to the kernel via VTIME, which avoids
+	// the extra system call but only has a precision of 100ms and a limit
+	// of 25.5s.  With Vmin above zero VTIME only starts once the first
+	// byte arrives, see SetMinReadBytes.
+	TimeoutVTIME
+)
+
+// BreakMode selects how a break received on the line is reported.
+type BreakMode int
+
+const (
+	// BreakAsNUL reads a received break as a single NUL byte, which can't be
+	// told apart from a NUL sent as data.  This is the default.
+	BreakAsNUL BreakMode = iota
+
+	// BreakIgnore discards received breaks (IGNBRK).
+	BreakIgnore
+
+	// BreakFlush flushes the input and output queues when a break is
+	// received (BRKINT), and sends SIGINT if the port is the controlling
+	// terminal of the process group.
+	BreakFlush
+
+	// BreakMarked reads a received break as the sequence 0xff 0x00 0x00
+	// (PARMRK), so it can be detected as a frame error.  To keep the
+	// sequence unambiguous a 0xff data byte is read as 0xff 0xff.
+	BreakMarked
+)
+
+// ParityCheck selects how received characters are checked for parity
+// errors.  Parity is generated on output whenever the configuration string
+// has a parity other than 'N', independent of the check; without parity
+// there is nothing to check.
+type ParityCheck int
+
+const (
+	// ParityUnchecked receives characters without checking their parity
+	// (INPCK off), so a quirky peer's parity is ignored.  This is the
+	// default.
+	ParityUnchecked ParityCheck = iota
+
+	// ParityDrop checks the parity and discards characters with parity or
+	// framing errors (INPCK, IGNPAR).
+	ParityDrop
+
+	// ParityNUL checks the parity and reads characters with parity or
+	// framing errors as a NUL byte (INPCK).
+	ParityNUL
+
+	// ParityMarked checks the parity and reads characters with parity or
+	// framing errors as 0xff 0x00 followed by the character (INPCK,
+	// PARMRK).  A 0xff data byte is read as 0xff 0xff.
+	ParityMarked
+)
+
+// Serial structure
+type Serial struct {
+	Name      string // The filename of the serial port
+	Baud      int    // The baud rate
+	Config    string // The configuration is a string in the form: '8N1' or similar, empty means DefaultConfig.
+	Canonical bool   // Read input a line at a time (ICANON)
+	Vmin      byte
+	Vtime     time.Duration
+
+	// EOL and EOL2 are additional line delimiters (VEOL and VEOL2) for
+	// Canonical and Terminal mode.  Zero disables them.  See SetEOL.
+	EOL  byte
+	EOL2 byte
+
+	// Polling makes Read return immediately with whatever data is available
+	// (VMIN=0, VTIME=0), overriding Vmin and Vtime.  In this mode a Read
+	// returning 0 bytes and a nil error simply means no data was waiting.
+	Polling bool
+
+	// OutputProcessing enables the kernel's output processing (OPOST|ONLCR)
+	// which translates LF into CRLF.  It defaults to off so the bytes written
+	// are sent to the port verbatim.
+	OutputProcessing bool
+
+	// CRToNL, IgnoreCR and NLToCR translate the input: CRToNL turns CR
+	// into NL (ICRNL), IgnoreCR drops CR (IGNCR) and NLToCR turns NL into
+	// CR (INLCR).  See SetInputTranslation.
+	CRToNL   bool
+	IgnoreCR bool
+	NLToCR   bool
+
+	// SignalChars makes the INTR, QUIT and SUSP characters received on the
+	// port send signals to the foreground process group (ISIG).  It is off
+	// by default, since a stray 0x03 in binary data would interrupt the
+	// process, and always on in Terminal mode.  See SetSignalChars.
+	SignalChars bool
+
+	// Terminal configures the port for an interactive console, such as a
+	// device's login shell: canonical input with echo, signal characters and
+	// CR to NL translation, plus output processing.
+	Terminal bool
+
+	// StrictDataBits makes Write fail, without writing anything, when a byte
+	// does not fit in the configured number of data bits, instead of letting
+	// the UART silently drop the high bits.
+	StrictDataBits bool
+
+	// WaitForData makes Read wait until at least one byte is available so
+	// it never returns 0 bytes with a nil error, as io.Reader recommends.
+	// The timeout set by SetReadTimeout applies to the whole wait and is
+	// reported as os.ErrDeadlineExceeded; without one Read waits forever.
+	WaitForData bool
+
+	// InterByteDelay, when non-zero, makes Write send one byte at a time,
+	// waiting for each byte to be transmitted and then pausing for the delay
+	// before the next, for peripherals that can't keep up with the line rate.
+	InterByteDelay time.Duration
+
+	// MaxWriteRate, when non-zero, caps how many bytes per second Write
+	// hands to the port, for devices with shallow input buffers and no flow
+	// control, for example 80% of the line rate.  Write is paced with a
+	// token bucket, sending chunks of about 10ms worth of data and sleeping
+	// as needed, which suits bulk transfers better than InterByteDelay.
+	// InterByteDelay takes precedence when both are set.
+	MaxWriteRate int
+
+	// Break selects how a received break is reported, see BreakMode.
+	Break BreakMode
+
+	// ParityChecking selects how the parity of received characters is
+	// checked, see ParityCheck.
+	ParityChecking ParityCheck
+
+	// RTSCTS enables hardware flow control (CRTSCTS) using the RTS and CTS
+	// lines.  See FlowControlActive to check that it is wired up.
+	RTSCTS bool
+
+	// DisableReceiver turns the receiver off (clears CREAD), for ports that
+	// are only used to send.  See EnableReceiver.
+	DisableReceiver bool
+
+	// ReadOnly and WriteOnly open the port for only one direction, for
+	// example to tap a line without being able to write to it.  Read or
+	// Write in the other direction then returns an error.  They take
+	// effect when the port is opened.
+	ReadOnly  bool
+	WriteOnly bool
+
+	// SyncWrites opens the port with O_SYNC.  It takes effect when the port
+	// is opened.  The tty layer hands written data to the driver before a
+	// write returns either way and doesn't wait for it to be transmitted,
+	// so O_SYNC mostly adds overhead; use WriteAndDrain or Drain to wait
+	// until the bytes are on the wire.
+	SyncWrites bool
+
+	// Multidrop enables the 9-bit addressing mode (ADDRB) used by some
+	// RS-485 multidrop buses.  See SetMultidrop.
+	Multidrop bool
+
+	// StopBits1Point5 sends 1.5 stop bits instead of the number in Config.
+	// Standard UARTs only support 1.5 stop bits with 5 data bits, where
+	// they use them in place of 2 (CSTOPB), so Config must have 5 data
+	// bits.
+	StopBits1Point5 bool
+
+	// FlushOnOpen discards any input that is already waiting when the port
+	// is opened, once it has been configured, so stale bytes received
+	// before the process started don't desync the first message.  It is
+	// off by default, keeping the input queued by the kernel.
+	FlushOnOpen bool
+
+	// Timeout is the default timeout.  The timed helpers, such as
+	// ReadFrame and ReadLineAuto, use it when they are passed a zero
+	// timeout, and Read and Write use it like a deadline that starts with
+	// each call.  A per call timeout, a deadline set with SetReadDeadline or
+	// SetWriteDeadline, or a read timeout set with SetReadTimeout takes
+	// precedence.  Zero, the default, waits forever.
+	Timeout time.Duration
+
+	// ReadTee and WriteTee, when set, receive a copy of every byte read
+	// from and written to the port, for example to dump the traffic while
+	// debugging.  Input read ahead by the timed read helpers is copied when
+	// it is read from the port.  Errors writing the copies are ignored.
+	ReadTee  io.Writer
+	WriteTee io.Writer
+
+	// MaxPayload is the largest payload ReadLengthPrefixed accepts, so a
+	// corrupt length prefix can't cause a huge allocation.  Zero means
+	// DefaultMaxPayload.
+	MaxPayload int
+
+	// AutoReconnect, when non-zero, makes a port whose device goes away,
+	// such as an unplugged USB adapter, reopen itself in the background by
+	// trying every AutoReconnect until it succeeds.  Meanwhile Read and
+	// Write return ErrReconnecting.  The disconnect stops the pump and the
+	// watchdog, which have to be started again once the port is back.  The
+	// disconnect and each reconnect attempt are logged to Logger.
+	AutoReconnect time.Duration
+
+	// OnClose, when set, is called each time the open port becomes closed,
+	// either by Close, with the error closing the file, or because the
+	// device went away and AutoReconnect is on, with the error that
+	// revealed it.  It is called once per close, from the goroutine that
+	// closed the port.
+	OnClose func(error)
+
+	// Logger, when set, receives debug records for opening, closing and
+	// reconfiguring the port as well as failed ioctls, and a warning for
+	// read timeouts shorter than the latency of the port.
+	Logger *slog.Logger
+
+	// KeepNonblocking leaves the file descriptor in non-blocking mode once
+	// the port is opened, for callers that drive it with their own
+	// poll or epoll loop using Fd with unix.Read and unix.Write.  The Read
+	// method then waits for data through the Go runtime's poller, so Vmin
+	// and Vtime no longer apply.  By default the port is switched to
+	// blocking mode.  It only takes effect when the port is opened.
+	KeepNonblocking bool
+
+	file *os.File
+
+	// fd is the file descriptor of file.  It is kept since every call to
+	// os.File.Fd switches the file back to blocking mode.
+	fd uintptr
+
+	// mu serializes the control operations, such as Flush and the break
+	// methods, and guards rbuf and skipLF.  It is never held while waiting
+	// for input.
+	mu sync.Mutex
+
+	// wmu makes each Write atomic with respect to other Writes.
+	wmu sync.Mutex
+
+	// paceTokens and paceLast are the token bucket for MaxWriteRate.  They
+	// are guarded by wmu.
+	paceTokens float64
+	paceLast   time.Time
+
+	// rbuf holds input read ahead by the timed read helpers that has not
+	// been consumed yet.  skipLF is set when a line ended with a CR so the
+	// LF of a CRLF pair is dropped.
+	rbuf   []byte
+	skipLF bool
+
+	// readTimeout is the timeout set by SetReadTimeout.  pollRead is set
+	// when it is handled by polling, as selected by timeoutMode.
+	readTimeout time.Duration
+	pollRead    bool
+	timeoutMode TimeoutMode
+
+	// blockForVmin is set by SetMinReadBytes to wait for Vmin bytes with
+	// VTIME turned off.
+	blockForVmin bool
+
+	// readDeadline and writeDeadline are the deadlines set by
+	// SetReadDeadline and SetWriteDeadline, guarded by mu.  Zero means no
+	// deadline.
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// waiters holds the eventfds of the polls in progress and dchange is
+	// closed when a deadline changes, both guarded by mu.  They wake the
+	// pending reads and writes so they notice a new deadline or Close.
+	waiters map[int]struct{}
+	dchange chan struct{}
+
+	// closing is set by Close while it stops the background goroutines,
+	// guarded by mu.  Waits fail as if the port was already closed.
+	closing bool
+
+	// breaking is set while a break started by SetBreak is being sent.
+	breaking bool
+
+	// pump is the background reader started by StartPump, guarded by mu.
+	pump *pump
+
+	// watchdog is closed to stop the watchdog started by WatchdogReapply.
+	// It is guarded by mu.
+	watchdog chan struct{}
+
+	// bytesRead and bytesWritten count the traffic for Stats since
+	// statsSince, which is guarded by mu.
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+	statsSince   time.Time
+
+	// txBase is the driver's transmit counter when the port was opened, or
+	// -1 if the driver has none.
+	txBase int
+
+	// histIn and histOut are the traffic history kept for History, guarded
+	// by hmu.  histOn is set while the history is on.
+	hmu     sync.Mutex
+	histIn  history
+	histOut history
+	histOn  atomic.Bool
+
+	// reconnecting is set while AutoReconnect is reopening the port.  It is
+	// guarded by mu.
+	reconnecting bool
+}
+
+// pump is the background reader started by StartPump.
+type pump struct {
+	limit int
+	err   error // Set when the pump stopped on an error, guarded by mu.
+	data  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// closed calls OnClose, if it is set.
+func (s *Serial) closed(err error) {
+	if nil != s.OnClose {
+		s.OnClose(err)
+	}
+}
+
+// logOp emits a debug record describing the outcome of an operation.
+func (s *Serial) logOp(op string, err error) {
+	if nil == s.Logger {
+		return
+	}
+
+	if nil != err {
+		s.Logger.Debug("serial port "+op+" failed", "port", s.Name, "error", err)
+		return
+	}
+	s.Logger.Debug("serial port "+op, "port", s.Name)
+}
+
+// ParseConfig validates a configuration string such as '8N1' and splits it
+// into the number of data bits, the parity character and the number of stop
+// bits, without needing an open port.  An empty string is treated as
+// DefaultConfig.
+func ParseConfig(cfg string) (dataBits int, parity byte, stopBits int, err error) {
+	if "" == cfg {
+		cfg = DefaultConfig
+	}
+
+	if len(cfg) < len(configParts) {
+		return 0, 0, 0, fmt.Errorf("Invalid configuration '%s': missing %s.", cfg, configParts[len(cfg)])
+	}
+	if len(configParts) < len(cfg) {
+		return 0, 0, 0, fmt.Errorf("Invalid configuration '%s': unexpected '%s' after the stop bits.", cfg, cfg[len(configParts):])
+	}
+
+	invalid := func(i int) error {
+		return fmt.Errorf("Invalid %s parameter '%c' at position %d of '%s'.", configParts[i], cfg[i], i+1, cfg)
+	}
+
+	dataBits = int(cfg[0]) - '0'
+	if dataBits < 5 || 8 < dataBits {
+		return 0, 0, 0, invalid(0)
+	}
+
+	parity = cfg[1]
+	if strings.IndexByte(parityChars, parity) < 0 {
+		return 0, 0, 0, invalid(1)
+	}
+
+	stopBits = int(cfg[2]) - '0'
+	if stopBits < 1 || 2 < stopBits {
+		return 0, 0, 0, invalid(2)
+	}
+
+	return dataBits, parity, stopBits, nil
+}
+
+// ByteDuration returns the time needed to transmit a single character using
+// the current baud rate and configuration.  This includes the start bit,
+// the data bits, the parity bit (if any) and the stop bits.
+func (s *Serial) ByteDuration() (time.Duration, error) {
+	if s.Baud <= 0 {
+		return 0, fmt.Errorf("Invalid baud rate parameter.")
+	}
+
+	dataBits, parity, stopBits, err := ParseConfig(s.Config)
+	if nil != err {
+		return 0, err
+	}
+
+	// Count in half bits for 1.5 stop bits.
+	halves := 2 * (1 + dataBits + stopBits)
+	if s.StopBits1Point5 {
+		halves = 2*(1+dataBits) + 3
+	}
+	if 'N' != parity {
+		halves += 2
+	}
+
+	return time.Duration(halves) * time.Second / time.Duration(2*s.Baud), nil
+}
+
+// check1Point5 ensures the configuration allows StopBits1Point5.
+func (s *Serial) check1Point5() error {
+	dataBits, _, _, err := ParseConfig(s.Config)
+	if nil != err {
+		return err
+	}
+	if 5 != dataBits {
+		return fmt.Errorf("Invalid configuration '%s': 1.5 stop bits need 5 data bits.", s.Config)
+	}
+
+	return nil
+}
+
+// checkDataBits ensures every byte fits in the configured data bits.
+func (s *Serial) checkDataBits(b []byte) error {
+	if 0 == len(s.Config) {
+		return nil
+	}
+
+	bits := uint(s.Config[0] - '0')
+	if 8 <= bits {
+		return nil
+	}
+
+	max := byte(1)<<bits - 1
+	for i, v := range b {
+		if max < v {
+			return fmt.Errorf("Byte 0x%02x at offset %d does not fit in %d data bits.", v, i, bits)
+		}
+	}
+
+	return nil
+}
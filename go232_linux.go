@@ -15,13 +15,17 @@
  *
  */
 
-// Package go232 provides a simple but usable way to interact with devices
-// that have serial ports.
 package go232
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 	"unsafe"
 
@@ -61,105 +65,392 @@ var baudMap = map[int]uint32{
 	4000000: unix.B4000000,
 }
 
-var dataBitsMap = map[byte]uint32{
-	'5': unix.CS5,
-	'6': unix.CS6,
-	'7': unix.CS7,
-	'8': unix.CS8,
+var dataBitsMap = map[int]uint32{
+	5: unix.CS5,
+	6: unix.CS6,
+	7: unix.CS7,
+	8: unix.CS8,
 }
 
-var stopBitsMap = map[byte]uint32{
-	'1': 0,
-	'2': unix.CSTOPB,
+var stopBitsMap = map[int]uint32{
+	1: 0,
+	2: unix.CSTOPB,
 }
 
 var parityMap = map[byte]uint32{
 	'N': 0,
 	'O': unix.PARENB | unix.PARODD,
 	'E': unix.PARENB,
+	'M': unix.PARENB | unix.CMSPAR | unix.PARODD,
+	'S': unix.PARENB | unix.CMSPAR,
 }
 
-// Serial structure
-type Serial struct {
-	Name      string // The filename of the serial port
-	Baud      int    // The baud rate
-	Config    string // The configuration is a string in the form: '8N1' or similar.
-	Canonical bool
-	Vmin      byte
-	Vtime     time.Duration
-	file      *os.File
+// ioctl issues the request on the file descriptor.  The request numbers and
+// termios flags always come from golang.org/x/sys/unix, which has the right
+// values for each architecture: several of them, such as TCSETS on MIPS and
+// PowerPC, differ from the x86 values.
+func ioctl(fd, req, arg uintptr) unix.Errno {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, req, arg)
+
+	return errno
 }
 
+// ioctlRetries bounds how often an ioctl interrupted by a signal is retried.
+const ioctlRetries = 10
+
+// ioctl issues the request on the port, retrying it if a signal interrupts
+// it, so programs that receive many signals don't see spurious EINTR
+// failures from the control operations such as Flush, Drain and SendBreak.
 func (s *Serial) ioctl(req, arg uintptr) unix.Errno {
 	if nil == s.file {
 		return unix.EBADFD
 	}
 
-	_, _, errno := unix.Syscall(unix.SYS_IOCTL, s.file.Fd(), req, arg)
+	errno := ioctl(s.fd, req, arg)
+	for i := 0; unix.EINTR == errno && i < ioctlRetries; i++ {
+		errno = ioctl(s.fd, req, arg)
+	}
+	if 0 != errno && nil != s.Logger {
+		s.Logger.Debug("serial port ioctl failed",
+			"port", s.Name,
+			"request", fmt.Sprintf("0x%x", req),
+			"errno", int(errno),
+			"error", errno.Error())
+	}
 
 	return errno
 }
 
-func validateConfig(baud int, cfg string, canonical bool) (rate, flags uint32, err error) {
-	if tmp, ok := baudMap[baud]; ok {
-		rate = tmp
-	} else {
-		return 0, 0, fmt.Errorf("Invalid baud rate parameter.")
-	}
+// isTTY reports if the file descriptor refers to a terminal by probing it
+// with TCGETS.
+func isTTY(fd uintptr) (bool, error) {
+	var t unix.Termios
 
-	if tmp, ok := dataBitsMap[cfg[0]]; ok {
-		flags |= tmp
-	} else {
-		return 0, 0, fmt.Errorf("Invalid data bits parameter.")
+	errno := ioctl(fd, uintptr(unix.TCGETS), uintptr(unsafe.Pointer(&t)))
+	switch errno {
+	case 0:
+		return true, nil
+	case unix.ENOTTY, unix.EINVAL:
+		return false, nil
 	}
 
-	if tmp, ok := parityMap[cfg[1]]; ok {
-		flags |= tmp
-	} else {
-		return 0, 0, fmt.Errorf("Invalid parity parameter.")
+	return false, errno
+}
+
+// IsSerialPort reports if the named file is a serial (tty) device.
+func IsSerialPort(name string) (bool, error) {
+	f, err := os.OpenFile(name, unix.O_RDONLY|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if nil != err {
+		return false, err
 	}
+	defer f.Close()
 
-	if tmp, ok := stopBitsMap[cfg[2]]; ok {
-		flags |= tmp
-	} else {
-		return 0, 0, fmt.Errorf("Invalid stop bits parameter.")
+	return isTTY(f.Fd())
+}
+
+func validateConfig(baud int, cfg string) (rate, flags uint32, err error) {
+	rate, err = baudRate(baud)
+	if nil != err {
+		return 0, 0, err
 	}
 
-	if canonical {
-		flags |= unix.ICANON
+	dataBits, parity, stopBits, err := ParseConfig(cfg)
+	if nil != err {
+		return 0, 0, err
 	}
 
+	flags = dataBitsMap[dataBits] | parityMap[parity] | stopBitsMap[stopBits]
+
 	return rate, flags, nil
 }
 
 // Close closes the serial port or returns an error if one happens
 func (s *Serial) Close() error {
-	if nil != s.file {
-		s.file.Close()
-		s.file = nil
+	if open, err := s.closeFile(); open {
+		s.closed(err)
 	}
 
 	return nil
 }
 
-// SetBaud sets the baud rate for the serial port as well as the rest of
+// closeFile stops the background goroutines and closes the port without
+// calling OnClose, so callers holding a lock can call closed once they have
+// released it.  The reads and writes waiting on the port are woken first
+// and fail.  It reports whether the port was open, with the error closing
+// the file.
+func (s *Serial) closeFile() (bool, error) {
+	s.stopReconnect()
+
+	f := s.detachFile()
+	if nil == f {
+		return false, nil
+	}
+
+	err := f.Close()
+	s.logOp("close", nil)
+
+	return true, err
+}
+
+// detachFile wakes the pending waits, stops the watchdog and the pump and
+// then detaches the file from the port, returning it for the caller to
+// close.  It returns nil if the port wasn't open.
+func (s *Serial) detachFile() *os.File {
+	s.mu.Lock()
+	s.closing = true
+	s.wake()
+	s.mu.Unlock()
+
+	s.stopWatchdog()
+	s.StopPump()
+
+	s.mu.Lock()
+	f := s.file
+	s.file = nil
+	s.closing = false
+	s.rbuf = nil
+	s.skipLF = false
+	s.breaking = false
+	s.mu.Unlock()
+
+	return f
+}
+
+// UpdateCfg applies the baud rate for the serial port as well as the rest of
 // the configuration.  The configuration is a string in the form: '8N1' or
-// similar.
+// similar.  Baud rates that are not one of the standard rates are set as a
+// custom rate, which the driver may round to the nearest rate it supports.
 func (s *Serial) UpdateCfg() error {
+	err := s.updateCfg()
+	s.logOp("reconfigure", err)
+
+	return err
+}
+
+// Configure sets the Serial fields to the line settings and applies them
+// if the port is open.
+func (s *Serial) Configure(c Config) error {
+	s.setConfig(c)
+	if nil == s.file {
+		return nil
+	}
+
+	return s.UpdateCfg()
+}
+
+// ConfigureVerified applies the line settings like Configure and then reads
+// them back from the port, returning an error listing the differences if
+// the driver silently changed or ignored any of them.  The settings that
+// drivers are known to adjust are checked: the baud rate, the framing,
+// RTSCTS, DisableReceiver and Multidrop.  On an error the Serial fields
+// keep the requested settings.
+func (s *Serial) ConfigureVerified(c Config) error {
+	if err := s.Configure(c); nil != err {
+		return err
+	}
+
+	actual, err := s.actualConfig()
+	if nil != err {
+		return err
+	}
+
+	if diff := s.GetConfig().Diff(actual); 0 < len(diff) {
+		return fmt.Errorf("Serial port '%s' did not take the configuration (requested != actual): %s", s.Name, strings.Join(diff, ", "))
+	}
+
+	return nil
+}
+
+// actualConfig returns GetConfig with the settings ConfigureVerified checks
+// replaced by what the port reports.
+func (s *Serial) actualConfig() (Config, error) {
+	c := s.GetConfig()
+
+	t, err := s.getTermios2()
+	if nil != err {
+		return c, err
+	}
+
+	if baud, ok := BaudFromConstant(t.Cflag & unix.CBAUD); ok {
+		c.Baud = baud
+	} else {
+		c.Baud = int(t.Ospeed)
+	}
+
+	framing := []byte(DefaultConfig)
+	for bits, flag := range dataBitsMap {
+		if flag == t.Cflag&unix.CSIZE {
+			framing[0] = byte('0' + bits)
+		}
+	}
+	framing[1] = '?'
+	for parity, flags := range parityMap {
+		if flags == t.Cflag&(unix.PARENB|unix.PARODD|unix.CMSPAR) {
+			framing[1] = parity
+		}
+	}
+	switch {
+	case c.StopBits1Point5:
+		// CSTOPB means 1.5 stop bits, the stop bits in Config aren't used.
+		framing[2] = s.Config[2]
+	case 0 != t.Cflag&unix.CSTOPB:
+		framing[2] = '2'
+	}
+	c.Framing = string(framing)
+
+	c.RTSCTS = 0 != t.Cflag&unix.CRTSCTS
+	c.DisableReceiver = 0 == t.Cflag&unix.CREAD
+	c.Multidrop = 0 != t.Cflag&addrb
+
+	return c, nil
+}
+
+// ApplyPreset configures the port with a named configuration registered
+// with RegisterPreset, like Configure.
+func (s *Serial) ApplyPreset(name string) error {
+	cfg, err := lookupPreset(name)
+	if nil != err {
+		return err
+	}
+
+	return s.Configure(cfg)
+}
+
+// WithTempConfig runs fn with the port temporarily configured with c, such
+// as a lower baud rate for a device's setup command, and then restores the
+// original configuration, even if fn fails.  The output is drained before
+// each change so nothing queued is sent with the wrong settings.  The error
+// of fn is returned in preference to an error restoring the configuration.
+func (s *Serial) WithTempConfig(c Config, fn func() error) (err error) {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	orig := s.GetConfig()
+	if err := s.Drain(); nil != err {
+		return err
+	}
+
+	restore := func() error {
+		derr := s.Drain()
+		if cerr := s.Configure(orig); nil != cerr {
+			return cerr
+		}
+		return derr
+	}
+
+	if err := s.Configure(c); nil != err {
+		restore()
+		return err
+	}
+
+	defer func() {
+		if rerr := restore(); nil == err {
+			err = rerr
+		}
+	}()
+
+	return fn()
+}
+
+func (s *Serial) updateCfg() error {
 	if nil == s.file {
 		return fmt.Errorf("Serial port '%s' not open.", s.Name)
 	}
 
-	rate, flags, err := validateConfig(s.Baud, s.Config, s.Canonical)
+	t, err := s.buildTermios()
 	if nil != err {
 		return err
 	}
 
-	t := unix.Termios{
-		Iflag:  unix.IGNPAR,
-		Cflag:  unix.CREAD | unix.CLOCAL | rate | flags,
-		Ispeed: rate,
-		Ospeed: rate,
+	return s.applyTermios(t)
+}
+
+// applyTermios applies termios settings built by buildTermios, using the
+// termios2 interface for custom baud rates.
+func (s *Serial) applyTermios(t unix.Termios) error {
+	if unix.BOTHER == t.Cflag&unix.CBAUD {
+		return s.setTermios2(t)
+	}
+
+	return s.SetTermios(t)
+}
+
+// buildTermios returns the termios settings described by the Serial
+// fields, starting from raw mode.
+func (s *Serial) buildTermios() (unix.Termios, error) {
+	var t unix.Termios
+
+	_, flags, err := validateConfig(s.Baud, s.Config)
+	if nil != err {
+		return t, err
+	}
+	if s.StopBits1Point5 {
+		if err := s.check1Point5(); nil != err {
+			return t, err
+		}
+		flags |= unix.CSTOPB
+	}
+
+	MakeRaw(&t)
+	t.Iflag |= unix.IGNPAR
+	switch s.Break {
+	case BreakAsNUL:
+	case BreakIgnore:
+		t.Iflag |= unix.IGNBRK
+	case BreakFlush:
+		t.Iflag |= unix.BRKINT
+	case BreakMarked:
+		t.Iflag |= unix.PARMRK
+	default:
+		return t, fmt.Errorf("Invalid break mode: %d", s.Break)
+	}
+	switch s.ParityChecking {
+	case ParityUnchecked:
+	case ParityDrop:
+		t.Iflag |= unix.INPCK
+	case ParityNUL:
+		t.Iflag = t.Iflag&^unix.IGNPAR | unix.INPCK
+	case ParityMarked:
+		t.Iflag = t.Iflag&^unix.IGNPAR | unix.INPCK | unix.PARMRK
+	default:
+		return t, fmt.Errorf("Invalid parity check: %d", s.ParityChecking)
+	}
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB
+	t.Cflag |= unix.CREAD | unix.CLOCAL | flags
+	if s.DisableReceiver {
+		t.Cflag &^= unix.CREAD
+	}
+	if s.RTSCTS {
+		t.Cflag |= unix.CRTSCTS
+	}
+	if err := cfsetospeed(&t, s.Baud); nil != err {
+		return t, err
+	}
+	if err := cfsetispeed(&t, s.Baud); nil != err {
+		return t, err
+	}
+
+	t.Iflag |= inputTranslation(s.CRToNL, s.IgnoreCR, s.NLToCR)
+
+	// Output processing is explicitly set every time so the port never
+	// inherits a stale OPOST/ONLCR setting that mangles binary data.
+	if s.OutputProcessing {
+		t.Oflag = unix.OPOST | unix.ONLCR
+	}
+
+	if s.Canonical {
+		t.Lflag |= unix.ICANON
+	}
+
+	// MakeRaw cleared ISIG, so binary data can't generate signals unless
+	// they are asked for.
+	if s.SignalChars {
+		t.Lflag |= unix.ISIG
+	}
+
+	if s.Multidrop {
+		t.Cflag |= addrb
 	}
 
 	var vtime int64
@@ -173,75 +464,1321 @@ func (s *Serial) UpdateCfg() error {
 
 	t.Cc[unix.VMIN] = s.Vmin
 	t.Cc[unix.VTIME] = uint8(vtime)
+	if s.blockForVmin {
+		t.Cc[unix.VTIME] = 0
+	}
+	if s.Polling {
+		t.Cc[unix.VMIN] = 0
+		t.Cc[unix.VTIME] = 0
+	}
 
-	errno := s.ioctl(uintptr(unix.TCSETS), uintptr(unsafe.Pointer(&t)))
+	// The control characters are set last since some architectures share
+	// the VMIN and VTIME slots with VEOF and VEOL.
+	if s.Terminal {
+		t.Iflag |= unix.ICRNL
+		t.Oflag = unix.OPOST | unix.ONLCR
+		t.Lflag |= unix.ICANON | unix.ISIG | unix.IEXTEN |
+			unix.ECHO | unix.ECHOE | unix.ECHOK | unix.ECHOCTL | unix.ECHOKE
+		setTerminalChars(&t)
+	}
+	if s.Canonical || s.Terminal {
+		t.Cc[unix.VEOL] = s.EOL
+		t.Cc[unix.VEOL2] = s.EOL2
+	}
 
-	if 0 != errno {
-		return fmt.Errorf("ioctl( '%s', TCSETS, &t ) error: %d\n", s.Name, errno)
+	return t, nil
+}
+
+// setTerminalChars sets the control characters to the usual console
+// defaults.
+func setTerminalChars(t *unix.Termios) {
+	t.Cc[unix.VINTR] = 0x03    // ^C
+	t.Cc[unix.VQUIT] = 0x1c    // ^\
+	t.Cc[unix.VERASE] = 0x7f   // DEL
+	t.Cc[unix.VKILL] = 0x15    // ^U
+	t.Cc[unix.VEOF] = 0x04     // ^D
+	t.Cc[unix.VSTART] = 0x11   // ^Q
+	t.Cc[unix.VSTOP] = 0x13    // ^S
+	t.Cc[unix.VSUSP] = 0x1a    // ^Z
+	t.Cc[unix.VREPRINT] = 0x12 // ^R
+	t.Cc[unix.VWERASE] = 0x17  // ^W
+	t.Cc[unix.VLNEXT] = 0x16   // ^V
+}
+
+// SetMinReadBytes makes Read block until at least n bytes have arrived,
+// which suits protocols with a fixed size header.  VMIN is a single byte so
+// n is limited to 0 through 255.  A zero timeout waits for the bytes
+// without limit, otherwise the timeout becomes VTIME, which the kernel
+// starts after the first byte and restarts with each byte: Read returns
+// what it has once the line has been idle that long.  The setting is
+// applied if the port is open.
+func (s *Serial) SetMinReadBytes(n int, timeout time.Duration) error {
+	if n < 0 {
+		n = 0
+	}
+	if 255 < n {
+		n = 255
 	}
 
-	return unix.SetNonblock(int(s.file.Fd()), false)
+	s.Vmin = byte(n)
+	s.Vtime = timeout
+	s.blockForVmin = 0 == timeout
+	if nil == s.file {
+		return nil
+	}
+
+	return s.UpdateCfg()
 }
 
-// Open opens the specified file name for serial port access
-func (s *Serial) Open() error {
-	if nil != s.file {
-		return fmt.Errorf("Serial port '%s' already open.", s.Name)
+// GetReadMode returns the VMIN and VTIME values the port actually uses,
+// for checking why a Read blocks.  VTIME is in tenths of a second.  In
+// Canonical or Terminal mode the values don't apply, and on some
+// architectures they are the VEOF and VEOL characters instead.
+func (s *Serial) GetReadMode() (vmin, vtime byte, err error) {
+	t, err := s.GetTermios()
+	if nil != err {
+		return 0, 0, err
+	}
+
+	return t.Cc[unix.VMIN], t.Cc[unix.VTIME], nil
+}
+
+// SetTerminalMode switches the port to Terminal mode and applies it.
+func (s *Serial) SetTerminalMode() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	s.Terminal = true
+
+	return s.UpdateCfg()
+}
+
+// SetRaw switches the port to raw mode, turning off Terminal, Canonical
+// and OutputProcessing, and applies it.
+func (s *Serial) SetRaw() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
 	}
 
-	f, err := os.OpenFile(s.Name, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0666)
+	s.Terminal = false
+	s.Canonical = false
+	s.OutputProcessing = false
+
+	return s.UpdateCfg()
+}
+
+// EnableReceiver turns the receiver (CREAD) on or off without changing the
+// rest of the configuration.  While it is off no input is received.
+func (s *Serial) EnableReceiver(enable bool) error {
+	t, err := s.GetTermios()
 	if nil != err {
 		return err
 	}
-	s.file = f
 
-	return s.UpdateCfg()
+	if enable {
+		t.Cflag |= unix.CREAD
+	} else {
+		t.Cflag &^= unix.CREAD
+	}
+
+	if err := s.SetTermios(t); nil != err {
+		return err
+	}
+	s.DisableReceiver = !enable
+
+	return nil
 }
 
-// Write an array of bytes and return the number of bytes written
-func (s *Serial) Write(b []byte) (n int, err error) {
+// SetSignalChars turns the signal characters (ISIG) on or off without
+// changing the rest of the configuration.  Terminal mode needs them, so
+// they can't be turned off in it.
+func (s *Serial) SetSignalChars(enable bool) error {
+	if !enable && s.Terminal {
+		return fmt.Errorf("Serial port '%s' is in terminal mode, which needs the signal characters.", s.Name)
+	}
+
+	t, err := s.GetTermios()
+	if nil != err {
+		return err
+	}
+
+	if enable {
+		t.Lflag |= unix.ISIG
+	} else {
+		t.Lflag &^= unix.ISIG
+	}
+
+	if err := s.SetTermios(t); nil != err {
+		return err
+	}
+	s.SignalChars = enable
+
+	return nil
+}
+
+// SetParity changes only the parity, one of the characters 'N', 'O', 'E',
+// 'M' or 'S', leaving the rest of the configuration as it is on the port.
+func (s *Serial) SetParity(parity byte) error {
+	return s.setConfigPart(1, parity)
+}
+
+// SetDataBits changes only the number of data bits, 5 through 8, leaving
+// the rest of the configuration as it is on the port.
+func (s *Serial) SetDataBits(bits int) error {
+	return s.setConfigPart(0, byte('0'+bits))
+}
+
+// SetStopBits changes only the number of stop bits, 1 or 2, leaving the
+// rest of the configuration as it is on the port.
+func (s *Serial) SetStopBits(bits int) error {
+	return s.setConfigPart(2, byte('0'+bits))
+}
+
+// setConfigPart replaces the character at position i of the configuration
+// string and applies just the resulting framing bits to the port.
+func (s *Serial) setConfigPart(i int, c byte) error {
+	cfg := []byte(s.Config)
+	if 0 == len(cfg) {
+		cfg = []byte(DefaultConfig)
+	}
+	if i < len(cfg) {
+		cfg[i] = c
+	}
+
+	dataBits, parity, stopBits, err := ParseConfig(string(cfg))
+	if nil != err {
+		return err
+	}
+
+	t, err := s.GetTermios()
+	if nil != err {
+		return err
+	}
+
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CMSPAR | unix.CSTOPB
+	t.Cflag |= dataBitsMap[dataBits] | parityMap[parity] | stopBitsMap[stopBits]
+
+	if err := s.SetTermios(t); nil != err {
+		return err
+	}
+	s.Config = string(cfg)
+
+	return nil
+}
+
+// SetEOL sets the additional line delimiters (VEOL and VEOL2) that end a
+// line in Canonical or Terminal mode, for devices that terminate their
+// output with something other than a newline, such as 0x03.  Zero disables
+// a delimiter.  The port must be open and in Canonical or Terminal mode.
+func (s *Serial) SetEOL(eol, eol2 byte) error {
+	if !s.Canonical && !s.Terminal {
+		return fmt.Errorf("Serial port '%s' is not in canonical mode.", s.Name)
+	}
+
+	t, err := s.GetTermios()
+	if nil != err {
+		return err
+	}
+
+	t.Cc[unix.VEOL] = eol
+	t.Cc[unix.VEOL2] = eol2
+
+	if err := s.SetTermios(t); nil != err {
+		return err
+	}
+	s.EOL = eol
+	s.EOL2 = eol2
+
+	return nil
+}
+
+// inputTranslation returns the Iflag bits for the input translations.
+func inputTranslation(icrnl, igncr, inlcr bool) uint32 {
+	var flags uint32
+	if icrnl {
+		flags |= unix.ICRNL
+	}
+	if igncr {
+		flags |= unix.IGNCR
+	}
+	if inlcr {
+		flags |= unix.INLCR
+	}
+
+	return flags
+}
+
+// SetInputTranslation sets the translation of CR and NL in the input
+// (ICRNL, IGNCR and INLCR) without changing the rest of the configuration.
+// IGNCR takes precedence over ICRNL.
+func (s *Serial) SetInputTranslation(icrnl, igncr, inlcr bool) error {
+	t, err := s.GetTermios()
+	if nil != err {
+		return err
+	}
+
+	t.Iflag &^= unix.ICRNL | unix.IGNCR | unix.INLCR
+	t.Iflag |= inputTranslation(icrnl, igncr, inlcr)
+
+	if err := s.SetTermios(t); nil != err {
+		return err
+	}
+	s.CRToNL = icrnl
+	s.IgnoreCR = igncr
+	s.NLToCR = inlcr
+
+	return nil
+}
+
+// Fd returns the file descriptor of the open serial port.  Unlike
+// os.File.Fd it leaves the blocking mode alone, so it can be used together
+// with KeepNonblocking.  If the port isn't open it returns ^uintptr(0) like
+// os.File.Fd.
+func (s *Serial) Fd() uintptr {
 	if nil == s.file {
-		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+		return ^uintptr(0)
 	}
 
-	return s.file.Write(b)
+	return s.fd
 }
 
-// Read into the specified array of bytes and return the number of bytes written
-func (s *Serial) Read(b []byte) (n int, err error) {
+// GetTermios returns the raw termios settings (TCGETS) of the serial port.
+func (s *Serial) GetTermios() (unix.Termios, error) {
+	var t unix.Termios
+
 	if nil == s.file {
-		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+		return t, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	errno := s.ioctl(uintptr(unix.TCGETS), uintptr(unsafe.Pointer(&t)))
+	if 0 != errno {
+		return t, fmt.Errorf("ioctl( '%s', TCGETS, &t ) error: %d", s.Name, errno)
 	}
 
-	return s.file.Read(b)
+	return t, nil
 }
 
-// Flush any characters that may be in a incoming or outgoing buffer
-func (s *Serial) Flush() error {
+// SetTermios applies the raw termios settings (TCSETS) to the serial port.
+// This is an escape hatch for configurations the rest of the API doesn't
+// cover: no validation is performed and the settings are replaced by the
+// Serial fields the next time UpdateCfg is called.
+func (s *Serial) SetTermios(t unix.Termios) error {
 	if nil == s.file {
 		return fmt.Errorf("Serial port '%s' not open.", s.Name)
 	}
 
-	errno := s.ioctl(uintptr(unix.TCFLSH), uintptr(unix.TCIOFLUSH))
+	errno := s.ioctl(uintptr(unix.TCSETS), uintptr(unsafe.Pointer(&t)))
 	if 0 != errno {
-		return errno
+		return fmt.Errorf("ioctl( '%s', TCSETS, &t ) error: %d\n", s.Name, errno)
 	}
 
 	return nil
 }
 
-// SendBreak sends the serial break signal
-func (s *Serial) SendBreak() error {
+// GetTermiosLock returns the termios lock mask (TIOCGLCKTRMIOS) of the serial
+// port.  Every bit that is set in a flag field, and every non-zero control
+// character, is locked: attempts by any process to change it are ignored.
+func (s *Serial) GetTermiosLock() (unix.Termios, error) {
+	var t unix.Termios
+
 	if nil == s.file {
-		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+		return t, fmt.Errorf("Serial port '%s' not open.", s.Name)
 	}
 
-	errno := s.ioctl(uintptr(unix.TCSBRKP), uintptr(0))
+	errno := s.ioctl(uintptr(unix.TIOCGLCKTRMIOS), uintptr(unsafe.Pointer(&t)))
 	if 0 != errno {
-		return errno
+		return t, fmt.Errorf("ioctl( '%s', TIOCGLCKTRMIOS, &t ) error: %d", s.Name, errno)
 	}
 
-	return nil
+	return t, nil
+}
+
+// LockTermios sets the termios lock mask (TIOCSLCKTRMIOS) of the serial port
+// so the settings selected by the mask can't be changed, for example to stop
+// another program from changing the baud rate mid session.  The lock stays
+// in place after the port is closed; pass an empty mask to remove it.
+// Changing the lock requires CAP_SYS_ADMIN.
+func (s *Serial) LockTermios(mask unix.Termios) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	errno := s.ioctl(uintptr(unix.TIOCSLCKTRMIOS), uintptr(unsafe.Pointer(&mask)))
+	if 0 != errno {
+		return fmt.Errorf("ioctl( '%s', TIOCSLCKTRMIOS, &mask ) error: %d", s.Name, errno)
+	}
+
+	return nil
+}
+
+// Line disciplines for SetLineDiscipline, from linux/tty.h, which x/sys
+// doesn't define.
+const (
+	LdiscTTY = 0  // N_TTY, the normal terminal line discipline
+	LdiscPPS = 18 // N_PPS, pulse per second timing from the DCD line
+)
+
+// GetLineDiscipline returns the line discipline (TIOCGETD) attached to the
+// serial port, such as LdiscTTY.
+func (s *Serial) GetLineDiscipline() (int, error) {
+	if nil == s.file {
+		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	var ldisc int32
+	errno := s.ioctl(uintptr(unix.TIOCGETD), uintptr(unsafe.Pointer(&ldisc)))
+	if 0 != errno {
+		return 0, fmt.Errorf("ioctl( '%s', TIOCGETD, &ldisc ) error: %d", s.Name, errno)
+	}
+
+	return int(ldisc), nil
+}
+
+// SetLineDiscipline attaches a kernel line discipline (TIOCSETD) to the
+// serial port, for example LdiscPPS to feed a GPS receiver's pulse per
+// second signal to the kernel's PPS support.  LdiscTTY restores the normal
+// discipline.  The discipline must be built into the kernel or
+// loadable as a module.
+func (s *Serial) SetLineDiscipline(ldisc int) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	v := int32(ldisc)
+	errno := s.ioctl(uintptr(unix.TIOCSETD), uintptr(unsafe.Pointer(&v)))
+	if 0 != errno {
+		return fmt.Errorf("ioctl( '%s', TIOCSETD, &ldisc ) error: %d", s.Name, errno)
+	}
+
+	return nil
+}
+
+// Open opens the specified file name for serial port access.  The device is
+// opened with O_NONBLOCK so the open doesn't wait for the carrier detect
+// line; once it succeeds the port is switched to blocking mode, unless
+// KeepNonblocking is set, so Read and Write block as configured from the
+// start.
+func (s *Serial) Open() error {
+	err := s.open()
+	s.logOp("open", err)
+
+	return err
+}
+
+func (s *Serial) open() error {
+	if nil != s.file {
+		return fmt.Errorf("Serial port '%s' already open.", s.Name)
+	}
+
+	f, err := s.openFile()
+	if nil != err {
+		return err
+	}
+	s.file = f
+	s.fd = f.Fd()
+
+	return s.setup()
+}
+
+// setup configures a newly opened port and, with FlushOnOpen, discards the
+// stale input.  The flush comes last since input received before the port
+// was configured is meaningless.
+func (s *Serial) setup() error {
+	s.startStats()
+	s.txBase = -1
+	if c, err := s.Counters(); nil == err {
+		s.txBase = c.Tx
+	}
+
+	// The blocking mode is set once here, rather than with every change
+	// of the line settings, so it doesn't depend on when the port is
+	// configured.
+	if err := unix.SetNonblock(int(s.fd), s.KeepNonblocking); nil != err {
+		return fmt.Errorf("Unable to set the blocking mode of '%s': %w", s.Name, err)
+	}
+
+	if err := s.UpdateCfg(); nil != err {
+		return err
+	}
+	if !s.FlushOnOpen {
+		return nil
+	}
+
+	return s.FlushInput()
+}
+
+// OpenFromFile uses a serial port that was opened elsewhere, for example a
+// file descriptor inherited from a parent process, and configures it like
+// Open.  The file is checked to be a terminal first, so a pipe or regular
+// file is rejected.  If Name is empty it is set to the file's name for
+// error messages.  The Serial takes ownership of the file: Close closes it.
+func (s *Serial) OpenFromFile(f *os.File) error {
+	err := s.openFromFile(f)
+	s.logOp("open", err)
+
+	return err
+}
+
+func (s *Serial) openFromFile(f *os.File) error {
+	if nil != s.file {
+		return fmt.Errorf("Serial port '%s' already open.", s.Name)
+	}
+	if "" == s.Name {
+		s.Name = f.Name()
+	}
+
+	fd := f.Fd()
+	tty, err := isTTY(fd)
+	if nil != err {
+		return fmt.Errorf("Unable to check if '%s' is a serial port: %w", s.Name, err)
+	}
+	if !tty {
+		return fmt.Errorf("'%s' is not a serial port.", s.Name)
+	}
+
+	s.file = f
+	s.fd = fd
+
+	return s.setup()
+}
+
+// OpenContext opens the serial port like Open, but gives up and returns
+// ctx.Err() if the context is done before the open completes.  Since the
+// open system call itself can't be interrupted, an abandoned open finishes
+// in the background and the resulting file is closed.
+func (s *Serial) OpenContext(ctx context.Context) error {
+	err := s.openContext(ctx)
+	s.logOp("open", err)
+
+	return err
+}
+
+func (s *Serial) openContext(ctx context.Context) error {
+	if nil != s.file {
+		return fmt.Errorf("Serial port '%s' already open.", s.Name)
+	}
+	if err := ctx.Err(); nil != err {
+		return err
+	}
+
+	type result struct {
+		f   *os.File
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		f, err := s.openFile()
+		done <- result{f: f, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		if nil != r.err {
+			return r.err
+		}
+		s.file = r.f
+		s.fd = r.f.Fd()
+
+		return s.setup()
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; nil != r.f {
+				r.f.Close()
+			}
+		}()
+
+		return ctx.Err()
+	}
+}
+
+// OpenFirstMatch opens the first serial port whose name matches the shell
+// pattern, such as '/dev/ttyUSB*', trying the matches in sorted order.  On
+// success Name is set to the port that was opened.  If none of the matches
+// can be opened the error lists why each of them failed.
+func (s *Serial) OpenFirstMatch(pattern string) error {
+	if nil != s.file {
+		return fmt.Errorf("Serial port '%s' already open.", s.Name)
+	}
+
+	names, err := filepath.Glob(pattern)
+	if nil != err {
+		return err
+	}
+	if 0 == len(names) {
+		return fmt.Errorf("No serial port matches '%s'.", pattern)
+	}
+
+	orig := s.Name
+	var errs []error
+	for _, name := range names {
+		s.Name = name
+		err := s.Open()
+		if nil == err {
+			return nil
+		}
+		s.Close()
+		errs = append(errs, fmt.Errorf("'%s': %w", name, err))
+	}
+	s.Name = orig
+
+	return fmt.Errorf("No serial port matching '%s' could be opened: %w", pattern, errors.Join(errs...))
+}
+
+// openFile opens the device node and verifies it is a serial device.
+func (s *Serial) openFile() (*os.File, error) {
+	mode := unix.O_RDWR
+	switch {
+	case s.ReadOnly && s.WriteOnly:
+		return nil, fmt.Errorf("Serial port '%s' can't be both read only and write only.", s.Name)
+	case s.ReadOnly:
+		mode = unix.O_RDONLY
+	case s.WriteOnly:
+		mode = unix.O_WRONLY
+	}
+
+	if s.SyncWrites {
+		mode |= unix.O_SYNC
+	}
+
+	// The permissions are only used when a file is created, which never
+	// happens without O_CREAT: the device node must already exist, and
+	// its mode and ownership are left to udev.
+	f, err := os.OpenFile(s.Name, mode|unix.O_NOCTTY|unix.O_NONBLOCK, 0666)
+	if nil != err {
+		return nil, err
+	}
+
+	tty, err := isTTY(f.Fd())
+	if nil != err {
+		f.Close()
+		return nil, err
+	}
+	if !tty {
+		f.Close()
+		return nil, fmt.Errorf("'%s' is not a serial device.", s.Name)
+	}
+
+	return f, nil
+}
+
+// Write an array of bytes and return the number of bytes written
+//
+// Write is safe for concurrent use: each call sends all of its bytes before
+// another Write starts, so messages from different goroutines are never
+// interleaved on the wire.
+//
+// Write doesn't buffer or coalesce the output: the bytes are handed to the
+// kernel before it returns, so only InterByteDelay and MaxWriteRate delay
+// them.  See WriteNow for bytes that can't wait for that pacing.
+func (s *Serial) Write(b []byte) (n int, err error) {
+	if s.isReconnecting() {
+		return 0, s.reconnectingErr()
+	}
+
+	n, err = s.write(b)
+
+	return n, s.checkDisconnect(err)
+}
+
+// WriteNow writes the bytes straight away, skipping the InterByteDelay and
+// MaxWriteRate pacing, for urgent control bytes interleaved with paced
+// bulk data.  It doesn't wait for a Write in progress either: while a paced
+// Write waits between its chunks the bytes go out ahead of the rest of it,
+// otherwise right after the chunk the kernel is accepting.  The write
+// deadline still applies.  Without pacing WriteNow behaves like Write,
+// since the output is never buffered.
+func (s *Serial) WriteNow(b []byte) (n int, err error) {
+	if s.isReconnecting() {
+		return 0, s.reconnectingErr()
+	}
+
+	n, err = s.writeNow(b)
+
+	return n, s.checkDisconnect(err)
+}
+
+// writeNow is WriteNow without the disconnect handling.
+func (s *Serial) writeNow(b []byte) (n int, err error) {
+	if err := s.checkWrite(b); nil != err {
+		return 0, err
+	}
+
+	deadline := s.writeDeadlineFunc()
+	if !deadline().IsZero() {
+		n, err = s.writeTimed(b, deadline)
+	} else {
+		n, err = s.file.Write(b)
+	}
+	s.sent(b[:n])
+
+	return n, err
+}
+
+// checkWrite returns the error for writing b to a port that isn't open, is
+// read only or, with StrictDataBits, for bytes that don't fit.
+func (s *Serial) checkWrite(b []byte) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if s.ReadOnly {
+		return fmt.Errorf("Serial port '%s' is open read only.", s.Name)
+	}
+	if s.StrictDataBits {
+		return s.checkDataBits(b)
+	}
+
+	return nil
+}
+
+// write is Write without the disconnect handling.
+func (s *Serial) write(b []byte) (n int, err error) {
+	if err := s.checkWrite(b); nil != err {
+		return 0, err
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	// The port may have been shut down while waiting for another Write.
+	if nil == s.file {
+		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	deadline := s.writeDeadlineFunc()
+	if 0 < s.InterByteDelay {
+		n, err = s.writeSlow(b)
+	} else if 0 < s.MaxWriteRate {
+		n, err = s.writePaced(b, deadline)
+	} else if !deadline().IsZero() {
+		n, err = s.writeTimed(b, deadline)
+	} else {
+		n, err = s.file.Write(b)
+	}
+	s.sent(b[:n])
+
+	return n, err
+}
+
+// WriteWithChecksum appends the checksum bytes cksum computes over the
+// payload, for example the bytes of one of the crc package checksums in the
+// protocol's byte order, and writes the frame with a single atomic Write.
+// It returns the number of bytes of the frame written.
+func (s *Serial) WriteWithChecksum(payload []byte, cksum func([]byte) []byte) (int, error) {
+	frame := append(payload[:len(payload):len(payload)], cksum(payload)...)
+
+	return s.Write(frame)
+}
+
+// tee copies the bytes to w, if there is one.  Errors are ignored since the
+// copy is only a debugging aid.
+func tee(w io.Writer, b []byte) {
+	if nil != w && 0 < len(b) {
+		w.Write(b)
+	}
+}
+
+// received counts the bytes read from the port, records them in the
+// history and copies them to ReadTee.
+func (s *Serial) received(b []byte) {
+	s.bytesRead.Add(uint64(len(b)))
+	s.record(&s.histIn, b)
+	tee(s.ReadTee, b)
+}
+
+// sent counts the bytes written to the port, records them in the history
+// and copies them to WriteTee.
+func (s *Serial) sent(b []byte) {
+	s.bytesWritten.Add(uint64(len(b)))
+	s.record(&s.histOut, b)
+	tee(s.WriteTee, b)
+}
+
+// writePaced writes the bytes in chunks, waiting for the MaxWriteRate token
+// bucket to refill before each one.  The bucket holds one chunk, so at most
+// that much is sent in a burst after an idle period.  A zero deadline
+// waits forever.
+func (s *Serial) writePaced(b []byte, deadline func() time.Time) (n int, err error) {
+	rate := float64(s.MaxWriteRate)
+	chunk := max(1, min(writeChunk, s.MaxWriteRate/100))
+
+	for n < len(b) {
+		end := min(len(b), n+chunk)
+		want := float64(end - n)
+
+		now := time.Now()
+		s.paceTokens = math.Min(float64(chunk), s.paceTokens+now.Sub(s.paceLast).Seconds()*rate)
+		s.paceLast = now
+		if s.paceTokens < want {
+			wait := time.Duration((want - s.paceTokens) / rate * float64(time.Second))
+			if d := deadline(); !d.IsZero() && d.Before(now.Add(wait)) {
+				return n, os.ErrDeadlineExceeded
+			}
+			time.Sleep(wait)
+			s.paceTokens = want
+			s.paceLast = now.Add(wait)
+		}
+		s.paceTokens -= want
+
+		var w int
+		if deadline().IsZero() {
+			w, err = s.file.Write(b[n:end])
+		} else {
+			w, err = s.writeTimed(b[n:end], deadline)
+		}
+		n += w
+		if nil != err {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// writeSlow writes the bytes one at a time with InterByteDelay between them.
+func (s *Serial) writeSlow(b []byte) (n int, err error) {
+	for i := range b {
+		if 0 < i {
+			if err := s.Drain(); nil != err {
+				return n, err
+			}
+			time.Sleep(s.InterByteDelay)
+		}
+
+		if _, err := s.file.Write(b[i : i+1]); nil != err {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// Read into the specified array of bytes and return the number of bytes written
+//
+// Unless the port is in canonical mode, a Read that returns because Vtime
+// expired, because the read timeout passed or because no data was available
+// in Polling mode returns 0 bytes and a nil error.  Callers that treat
+// (0, nil) as suspicious, as io.Reader suggests, should set WaitForData.
+//
+// When a deadline has been set with SetReadDeadline, Read waits for data
+// until the deadline and then returns os.ErrDeadlineExceeded.  The default
+// Timeout works the same way when neither a deadline nor a read timeout is
+// set.
+func (s *Serial) Read(b []byte) (n int, err error) {
+	if s.isReconnecting() {
+		return 0, s.reconnectingErr()
+	}
+
+	n, err = s.read(b)
+
+	return n, s.checkDisconnect(err)
+}
+
+// read is Read without the disconnect handling.
+func (s *Serial) read(b []byte) (n int, err error) {
+	if nil == s.file {
+		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if s.WriteOnly {
+		return 0, fmt.Errorf("Serial port '%s' is open write only.", s.Name)
+	}
+
+	if d := s.getReadDeadline(); !d.IsZero() && time.Now().After(d) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	s.mu.Lock()
+	if buf := s.buffered(); 0 < len(buf) {
+		n = copy(b, buf)
+		s.rbuf = buf[n:]
+		s.mu.Unlock()
+		return n, nil
+	}
+	s.mu.Unlock()
+
+	if p := s.currentPump(); nil != p {
+		return s.readPump(p, b)
+	}
+
+	// The default Timeout applies unless a read timeout handles the wait.
+	var timeout time.Time
+	if s.WaitForData || 0 == s.readTimeout {
+		timeout = s.deadlineFor(s.readTimeout)
+	}
+	deadline := func() time.Time {
+		return earliest(s.getReadDeadline(), timeout)
+	}
+	wait := s.WaitForData || !deadline().IsZero()
+
+	// A read that blocks until the first byte arrives waits in poll first,
+	// so a later deadline or Close can end the wait.
+	blocking := s.Canonical || s.Terminal || (!s.Polling && 0 < s.Vmin)
+
+	for {
+		switch {
+		case wait:
+			ready, err := s.pollUntil(unix.POLLIN, deadline)
+			if nil != err {
+				return 0, err
+			}
+			if !ready {
+				return 0, os.ErrDeadlineExceeded
+			}
+			n, err = s.readAvailable(b)
+		case s.pollRead:
+			ready, err := s.poll(unix.POLLIN, time.Now().Add(s.readTimeout))
+			if !ready || nil != err {
+				return 0, err
+			}
+			n, err = s.readAvailable(b)
+		default:
+			if blocking {
+				if _, err := s.pollUntil(unix.POLLIN, deadline); nil != err {
+					return 0, err
+				}
+			}
+			n, err = s.file.Read(b)
+			s.received(b[:n])
+		}
+
+		s.mu.Lock()
+		if s.skipLF && 0 < n {
+			s.skipLF = false
+			if '\n' == b[0] {
+				n = copy(b, b[1:n])
+			}
+		}
+		s.mu.Unlock()
+
+		// The os package reports a read without data as io.EOF, but outside
+		// of canonical mode it only means the VMIN/VTIME conditions were met.
+		if 0 == n && io.EOF == err && !s.Canonical && !s.Terminal {
+			err = nil
+		}
+
+		if 0 < n || nil != err || !wait {
+			return n, err
+		}
+	}
+}
+
+// Flush any characters that may be in a incoming or outgoing buffer
+//
+// The control operations (Flush, FlushInput, DrainThenFlushInput and the
+// break methods) are serialized with each other and with the buffer
+// handling in Read, so they are safe to call while another goroutine is
+// reading.  Input that has been read ahead but not yet returned is
+// discarded along with the driver's buffers.  A Read that is already
+// waiting in the kernel is not interrupted and returns the data that
+// arrives after the flush.
+func (s *Serial) Flush() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush(unix.TCIOFLUSH)
+}
+
+// FlushInput discards any characters that have been received but not read,
+// leaving pending output untouched.
+func (s *Serial) FlushInput() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush(unix.TCIFLUSH)
+}
+
+// flush discards the read ahead input and the driver's queues selected by
+// the TCFLSH argument.  The caller must hold s.mu.
+func (s *Serial) flush(queue int) error {
+	s.rbuf = nil
+	s.skipLF = false
+
+	errno := s.ioctl(uintptr(unix.TCFLSH), uintptr(queue))
+	if 0 != errno {
+		return errno
+	}
+
+	return nil
+}
+
+// FlowAction is a manual flow control action for FlowControlSignal.
+type FlowAction int
+
+const (
+	FlowSuspendOutput FlowAction = unix.TCOOFF // Suspend our output
+	FlowResumeOutput  FlowAction = unix.TCOON  // Resume our output
+	FlowSendXOFF      FlowAction = unix.TCIOFF // Send XOFF to pause the peer
+	FlowSendXON       FlowAction = unix.TCION  // Send XON to resume the peer
+)
+
+// SendXON writes the XON character, the configured VSTART or 0x11 if none
+// is set, regardless of the flow control mode.
+func (s *Serial) SendXON() error {
+	return s.sendFlowChar(unix.VSTART, 0x11)
+}
+
+// SendXOFF writes the XOFF character, the configured VSTOP or 0x13 if none
+// is set, regardless of the flow control mode.
+func (s *Serial) SendXOFF() error {
+	return s.sendFlowChar(unix.VSTOP, 0x13)
+}
+
+// sendFlowChar writes the control character at index cc of the termios, or
+// def if it isn't set.
+func (s *Serial) sendFlowChar(cc int, def byte) error {
+	t, err := s.GetTermios()
+	if nil != err {
+		return err
+	}
+
+	c := t.Cc[cc]
+	if 0 == c {
+		c = def
+	}
+
+	_, err = s.Write([]byte{c})
+
+	return err
+}
+
+// FlowControlSignal suspends or resumes the output, or sends XOFF or XON
+// to pause or resume the peer (TCXONC), like tcflow(3).
+func (s *Serial) FlowControlSignal(action FlowAction) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	switch action {
+	case FlowSuspendOutput, FlowResumeOutput, FlowSendXOFF, FlowSendXON:
+	default:
+		return fmt.Errorf("Invalid flow action: %d", action)
+	}
+
+	errno := s.ioctl(uintptr(unix.TCXONC), uintptr(action))
+	if 0 != errno {
+		return fmt.Errorf("ioctl( '%s', TCXONC, %d ) error: %w", s.Name, action, errno)
+	}
+
+	return nil
+}
+
+// DrainThenFlushInput waits until all pending output has been transmitted
+// and then discards any received input.  This is the safe way to finish
+// sending and throw away stale replies, unlike Flush which also discards
+// output that has not been sent yet.  Reads and the other control
+// operations aren't held up while the output drains, only the flush itself
+// is serialized with them.
+func (s *Serial) DrainThenFlushInput() error {
+	if err := s.Drain(); nil != err {
+		return err
+	}
+
+	return s.FlushInput()
+}
+
+// SendBreak sends the serial break signal
+func (s *Serial) SendBreak() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errno := s.ioctl(uintptr(unix.TCSBRKP), uintptr(0))
+	if 0 != errno {
+		return errno
+	}
+
+	return nil
+}
+
+// Drain waits until all of the output written to the serial port has been
+// transmitted.
+func (s *Serial) Drain() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	errno := s.ioctl(uintptr(unix.TCSBRK), uintptr(1))
+	if 0 != errno {
+		return errno
+	}
+
+	return nil
+}
+
+// OutputWaiting returns the number of bytes written to the serial port
+// that have not been transmitted yet (TIOCOUTQ).
+func (s *Serial) OutputWaiting() (int, error) {
+	if nil == s.file {
+		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	var waiting int32
+	errno := s.ioctl(uintptr(unix.TIOCOUTQ), uintptr(unsafe.Pointer(&waiting)))
+	if 0 != errno {
+		return 0, errno
+	}
+
+	return int(waiting), nil
+}
+
+// InputWaiting returns the number of bytes that have been received but not
+// read yet, including any input read ahead by the timed read helpers.
+func (s *Serial) InputWaiting() (int, error) {
+	if nil == s.file {
+		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	var waiting int32
+	errno := s.ioctl(uintptr(unix.TIOCINQ), uintptr(unsafe.Pointer(&waiting)))
+	if 0 != errno {
+		return 0, errno
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int(waiting) + len(s.buffered()), nil
+}
+
+// DrainInput returns all of the input that has been received but not read
+// yet, leaving the input empty.  Unlike FlushInput the data is returned so
+// it can be inspected, for example to resynchronize after a reconnect.  It
+// doesn't wait for more input.
+func (s *Serial) DrainInput() ([]byte, error) {
+	if nil == s.file {
+		return nil, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := s.buffered()
+	s.rbuf = nil
+
+	var waiting int32
+	errno := s.ioctl(uintptr(unix.TIOCINQ), uintptr(unsafe.Pointer(&waiting)))
+	if 0 != errno {
+		return data, errno
+	}
+	if 0 == waiting {
+		return data, nil
+	}
+
+	buf := make([]byte, waiting)
+	n, err := s.readAvailable(buf)
+
+	return append(data, buf[:n]...), err
+}
+
+// SendBreakFor sends the serial break signal for the specified duration.
+// Any pending output is transmitted before the break starts.  The duration
+// is a minimum since it depends on the scheduler for accuracy.
+func (s *Serial) SendBreakFor(d time.Duration) error {
+	if err := s.Drain(); nil != err {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.setBreak(true); nil != err {
+		return err
+	}
+
+	time.Sleep(d)
+
+	return s.setBreak(false)
+}
+
+// SetBreak starts sending the serial break signal (TIOCSBRK) until
+// ClearBreak is called.
+func (s *Serial) SetBreak() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setBreak(true)
+}
+
+// ClearBreak stops sending the serial break signal (TIOCCBRK).  It is safe
+// to call when no break is being sent, which makes it useful to make sure
+// this side of a stuck link isn't the one holding the line in break.
+func (s *Serial) ClearBreak() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setBreak(false)
+}
+
+// setBreak starts (TIOCSBRK) or stops (TIOCCBRK) sending the break signal.
+// The caller must hold s.mu.
+func (s *Serial) setBreak(on bool) error {
+	req := unix.TIOCCBRK
+	if on {
+		req = unix.TIOCSBRK
+	}
+
+	errno := s.ioctl(uintptr(req), uintptr(0))
+	if 0 != errno {
+		return errno
+	}
+	s.breaking = on
+
+	return nil
+}
+
+// BreakState reports if this side is sending a break started by SetBreak,
+// along with the number of breaks the driver has received.  A received
+// count that stops changing while no data arrives points to the peer
+// holding the line in break.  The count comes from Counters, so drivers
+// without counter support return an error.
+func (s *Serial) BreakState() (asserting bool, received int, err error) {
+	s.mu.Lock()
+	asserting = s.breaking
+	s.mu.Unlock()
+
+	c, err := s.Counters()
+	if nil != err {
+		return asserting, 0, err
+	}
+
+	return asserting, c.Brk, nil
+}
+
+// SendBreakBits sends the serial break signal for at least the specified
+// number of bit times at the configured baud rate.
+func (s *Serial) SendBreakBits(bits int) error {
+	if s.Baud < 1 {
+		return fmt.Errorf("Invalid baud rate parameter.")
+	}
+
+	return s.SendBreakFor(time.Duration(bits) * time.Second / time.Duration(s.Baud))
+}
+
+// LINHeader sends a LIN bus frame header: a break field of 13 bit times,
+// the 0x55 sync byte and the protected identifier.  The pid must already
+// include the identifier parity bits.
+func (s *Serial) LINHeader(pid byte) error {
+	if err := s.SendBreakBits(13); nil != err {
+		return err
+	}
+
+	_, err := s.Write([]byte{0x55, pid})
+
+	return err
+}
+
+// Transmission compares what was written to the port with what has been
+// sent on the line, see Transmitted.
+type Transmission struct {
+	Written     uint64 // Bytes written, from Stats
+	Queued      int    // Bytes written but not transmitted yet (TIOCOUTQ)
+	Transmitted uint64 // Estimated bytes transmitted: Written less Queued
+
+	// DriverTx is the number of bytes the driver counted as transmitted
+	// since the port was opened (TIOCGICOUNT), or -1 if the driver doesn't
+	// count them.
+	DriverTx int
+}
+
+// Transmitted estimates how much of the output has been physically sent,
+// to tell whether slow throughput comes from the application or the line.
+// Transmitted is exact up to the bytes in the UART's transmit FIFO, which
+// TIOCOUTQ may already count as sent, so it can be ahead of the line by up
+// to the FIFO size.  Written counts from when Stats started, while DriverTx
+// counts from when the port was last opened.
+func (s *Serial) Transmitted() (Transmission, error) {
+	queued, err := s.OutputWaiting()
+	if nil != err {
+		return Transmission{}, err
+	}
+
+	tx := Transmission{
+		Written:  s.bytesWritten.Load(),
+		Queued:   queued,
+		DriverTx: -1,
+	}
+	if uint64(queued) < tx.Written {
+		tx.Transmitted = tx.Written - uint64(queued)
+	}
+
+	if c, err := s.Counters(); nil == err && 0 <= s.txBase {
+		tx.DriverTx = c.Tx - s.txBase
+	}
+
+	return tx, nil
+}
+
+// Counters holds the interrupt counters the serial driver keeps for a port.
+type Counters struct {
+	CTS        int // Number of CTS line changes
+	DSR        int // Number of DSR line changes
+	RNG        int // Number of RI line changes
+	DCD        int // Number of DCD line changes
+	Rx         int // Number of bytes received
+	Tx         int // Number of bytes transmitted
+	Frame      int // Number of framing errors
+	Overrun    int // Number of hardware overrun errors
+	Parity     int // Number of parity errors
+	Brk        int // Number of breaks received
+	BufOverrun int // Number of buffer overrun errors
+}
+
+// icounter mirrors the kernel's struct serial_icounter_struct.
+type icounter struct {
+	cts, dsr, rng, dcd          int32
+	rx, tx                      int32
+	frame, overrun, parity, brk int32
+	bufOverrun                  int32
+	reserved                    [9]int32
+}
+
+// Counters returns the driver's interrupt counters (TIOCGICOUNT) for the
+// serial port.  Not every driver supports the counters.
+func (s *Serial) Counters() (Counters, error) {
+	if nil == s.file {
+		return Counters{}, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	var ic icounter
+	errno := s.ioctl(uintptr(unix.TIOCGICOUNT), uintptr(unsafe.Pointer(&ic)))
+	if 0 != errno {
+		return Counters{}, errno
+	}
+
+	return Counters{
+		CTS:        int(ic.cts),
+		DSR:        int(ic.dsr),
+		RNG:        int(ic.rng),
+		DCD:        int(ic.dcd),
+		Rx:         int(ic.rx),
+		Tx:         int(ic.tx),
+		Frame:      int(ic.frame),
+		Overrun:    int(ic.overrun),
+		Parity:     int(ic.parity),
+		Brk:        int(ic.brk),
+		BufOverrun: int(ic.bufOverrun),
+	}, nil
 }
 
 // FindSerialPorts finds and lists the available serial ports
@@ -0,0 +1,411 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFlushDiscardsReadAhead(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	// ReadUntil reads ahead of the pattern, leaving "stale" buffered.
+	writeAllTo(t, master, []byte("ok\nstale"))
+	waitInput(t, &port, 8)
+	if got, err := port.ReadUntil([]byte("\n"), testTimeout); nil != err || "ok\n" != string(got) {
+		t.Fatalf("ReadUntil() = %q, %v", got, err)
+	}
+	if n, _ := port.InputWaiting(); 5 != n {
+		t.Fatalf("InputWaiting() = %d, expected the 5 bytes read ahead", n)
+	}
+
+	if err := port.Flush(); nil != err {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	writeAllTo(t, master, []byte("fresh"))
+	if got := readN(t, &port, 5); "fresh" != string(got) {
+		t.Fatalf("Read() = %q, expected \"fresh\"", got)
+	}
+}
+
+func TestFlushWhileReadWaits(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	// A Read already waiting is not interrupted by the flush and returns
+	// the data that arrives after it.
+	port.SetReadDeadline(time.Now().Add(testTimeout))
+	done := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, 5)
+		n, _ := port.Read(b)
+		done <- b[:n]
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := port.Flush(); nil != err {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	writeAllTo(t, master, []byte("after"))
+
+	select {
+	case got := <-done:
+		if "after" != string(got) {
+			t.Fatalf("Read() = %q, expected \"after\"", got)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("Read() did not return")
+	}
+}
+
+func TestConcurrentReadAndFlush(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// Keep the input flowing.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		chunk := []byte("0123456789\n")
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			master.Write(chunk)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// Read with both Read and the read ahead helpers.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b := make([]byte, 7)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			port.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+			if _, err := port.Read(b); nil != err && !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Errorf("Read() error: %v", err)
+				return
+			}
+			port.ReadUntil([]byte("\n"), 10*time.Millisecond)
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		if err := port.Flush(); nil != err {
+			t.Fatalf("Flush() error: %v", err)
+		}
+		if err := port.FlushInput(); nil != err {
+			t.Fatalf("FlushInput() error: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	// The port is still consistent once the flushes are done.  The input
+	// written last is delivered by the pty asynchronously, so let it arrive
+	// before the final flush.
+	time.Sleep(50 * time.Millisecond)
+	if err := port.Flush(); nil != err {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	writeAllTo(t, master, []byte("end"))
+	if got := readN(t, &port, 3); "end" != string(got) {
+		t.Fatalf("Read() = %q, expected \"end\"", got)
+	}
+}
+
+func TestControlDuringSignals(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-sig:
+			default:
+				syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+				time.Sleep(100 * time.Microsecond)
+			}
+		}
+	}()
+
+	for end := time.Now().Add(250 * time.Millisecond); time.Now().Before(end); {
+		// Pending output gives the ioctls something to wait for.
+		writeAllTo(t, &port, []byte("pending"))
+		if err := port.SendBreak(); nil != err {
+			t.Fatalf("SendBreak() error during signals: %v", err)
+		}
+		if err := port.Drain(); nil != err {
+			t.Fatalf("Drain() error during signals: %v", err)
+		}
+		if err := port.Flush(); nil != err {
+			t.Fatalf("Flush() error during signals: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// The port keeps working once the signals stop.
+	master.Flush()
+	writeAllTo(t, &port, []byte("after"))
+	if got := readN(t, master, 5); "after" != string(got) {
+		t.Fatalf("master read %q, expected \"after\"", got)
+	}
+}
+
+func TestTerminalMode(t *testing.T) {
+	var port Serial
+	openPair(t, &port)
+
+	if err := port.SetTerminalMode(); nil != err {
+		t.Fatalf("SetTerminalMode() error: %v", err)
+	}
+	term, err := port.GetTermios()
+	if nil != err {
+		t.Fatalf("GetTermios() error: %v", err)
+	}
+	if want := uint32(unix.ICANON | unix.ECHO | unix.ISIG); want != term.Lflag&want {
+		t.Fatalf("Lflag = 0x%x in terminal mode, expected 0x%x set", term.Lflag, want)
+	}
+
+	if err := port.SetRaw(); nil != err {
+		t.Fatalf("SetRaw() error: %v", err)
+	}
+	term, err = port.GetTermios()
+	if nil != err {
+		t.Fatalf("GetTermios() error: %v", err)
+	}
+	if 0 != term.Lflag&(unix.ICANON|unix.ECHO) {
+		t.Fatalf("Lflag = 0x%x in raw mode, expected ICANON and ECHO off", term.Lflag)
+	}
+}
+
+func TestTerminalModeClosed(t *testing.T) {
+	s := Serial{Name: "closed", Canonical: true}
+
+	if err := s.SetTerminalMode(); nil == err {
+		t.Fatalf("SetTerminalMode() on a closed port succeeded")
+	}
+	if s.Terminal {
+		t.Fatalf("SetTerminalMode() on a closed port set Terminal")
+	}
+
+	if err := s.SetRaw(); nil == err {
+		t.Fatalf("SetRaw() on a closed port succeeded")
+	}
+	if !s.Canonical {
+		t.Fatalf("SetRaw() on a closed port cleared Canonical")
+	}
+}
+
+func TestWritePacing(t *testing.T) {
+	tests := []struct {
+		description    string
+		interByteDelay time.Duration
+		maxWriteRate   int
+		size           int
+		atLeast        time.Duration
+	}{
+		{
+			description:    "inter byte delay",
+			interByteDelay: 5 * time.Millisecond,
+			size:           10,
+			atLeast:        45 * time.Millisecond,
+		}, {
+			// The bucket holds 10ms of data, so only that much goes out
+			// ahead of the rate.
+			description:  "max write rate",
+			maxWriteRate: 1000,
+			size:         200,
+			atLeast:      180 * time.Millisecond,
+		}, {
+			description:    "inter byte delay takes precedence",
+			interByteDelay: 5 * time.Millisecond,
+			maxWriteRate:   1000000,
+			size:           10,
+			atLeast:        45 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			port := Serial{InterByteDelay: tc.interByteDelay, MaxWriteRate: tc.maxWriteRate}
+			master := openPair(t, &port)
+
+			sent := make([]byte, tc.size)
+			for i := range sent {
+				sent[i] = byte(i)
+			}
+
+			start := time.Now()
+			writeAllTo(t, &port, sent)
+			if elapsed := time.Since(start); elapsed < tc.atLeast {
+				t.Fatalf("Write() of %d bytes took %s, expected at least %s", tc.size, elapsed, tc.atLeast)
+			}
+			if got := readN(t, master, tc.size); !bytes.Equal(sent, got) {
+				t.Fatalf("master read % x, expected % x", got, sent)
+			}
+		})
+	}
+}
+
+func TestWithTempConfigRestores(t *testing.T) {
+	var port Serial
+	openPair(t, &port)
+
+	errFn := errors.New("fn failed")
+
+	temp := port.GetConfig()
+	temp.Baud = 300
+	err := port.WithTempConfig(temp, func() error {
+		if _, _, output, err := port.EffectiveBaud(); nil != err || 300 != output {
+			t.Errorf("EffectiveBaud() in fn = %d, %v, expected 300", output, err)
+		}
+		return errFn
+	})
+	if errFn != err {
+		t.Fatalf("WithTempConfig() = %v, expected the error of fn", err)
+	}
+
+	invalid := port.GetConfig()
+	invalid.Framing = "9X1"
+	if err := port.WithTempConfig(invalid, func() error { return nil }); nil == err {
+		t.Fatalf("WithTempConfig() with an invalid configuration succeeded")
+	}
+
+	if 9600 != port.Baud || "" != port.Config {
+		t.Fatalf("the fields are Baud %d, Config %q after WithTempConfig()", port.Baud, port.Config)
+	}
+	if _, _, output, err := port.EffectiveBaud(); nil != err || 9600 != output {
+		t.Fatalf("EffectiveBaud() = %d, %v after WithTempConfig(), expected 9600", output, err)
+	}
+}
+
+func TestOpenBlockingMode(t *testing.T) {
+	tests := []struct {
+		description     string
+		keepNonblocking bool
+	}{
+		{description: "blocking by default"},
+		{description: "kept non-blocking", keepNonblocking: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			port := Serial{KeepNonblocking: tc.keepNonblocking}
+			openPair(t, &port)
+
+			check := func(when string) {
+				t.Helper()
+
+				flags, err := unix.FcntlInt(port.fd, unix.F_GETFL, 0)
+				if nil != err {
+					t.Fatalf("F_GETFL error: %v", err)
+				}
+				if nonblock := 0 != flags&unix.O_NONBLOCK; tc.keepNonblocking != nonblock {
+					t.Fatalf("O_NONBLOCK = %v %s, expected %v", nonblock, when, tc.keepNonblocking)
+				}
+			}
+
+			check("after Open()")
+
+			// Changing the line settings leaves the blocking mode alone.
+			port.Baud = 115200
+			if err := port.UpdateCfg(); nil != err {
+				t.Fatalf("UpdateCfg() error: %v", err)
+			}
+			check("after UpdateCfg()")
+		})
+	}
+}
+
+func TestWriteNowSkipsPacing(t *testing.T) {
+	port := Serial{MaxWriteRate: 100}
+	master := openPair(t, &port)
+
+	bulk := make([]byte, 50)
+	for i := range bulk {
+		bulk[i] = byte(i)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := port.Write(bulk)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if n, err := port.WriteNow([]byte{0xff}); 1 != n || nil != err {
+		t.Fatalf("WriteNow() = %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); 100*time.Millisecond < elapsed {
+		t.Fatalf("WriteNow() waited %s for the paced Write", elapsed)
+	}
+
+	got := readN(t, master, len(bulk)+1)
+	if i := bytes.IndexByte(got, 0xff); i < 0 || len(bulk) <= i {
+		t.Fatalf("master read % x, expected 0xff ahead of the end of the bulk data", got)
+	}
+	if err := waitErr(t, done); nil != err {
+		t.Fatalf("Write() error: %v", err)
+	}
+}
+
+func TestWriteNowReadOnly(t *testing.T) {
+	port := Serial{ReadOnly: true}
+	openPair(t, &port)
+
+	if _, err := port.WriteNow([]byte{0}); nil == err {
+		t.Fatalf("WriteNow() on a read only port succeeded")
+	}
+}
@@ -0,0 +1,192 @@
+//go:build hardware
+// +build hardware
+
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+// The hardware tests run against a real serial adapter with its TX pin tied
+// to its RX pin, to exercise the driver paths a pty can't: real baud rates,
+// flow control, break and the modem lines.  They only build with the
+// hardware tag and are skipped unless GO232_LOOPBACK names the port:
+//
+//	GO232_LOOPBACK=/dev/ttyUSB0 go test -tags hardware -run Loopback .
+//
+// On a DB9 connector the loopback is pin 3 (TX) to pin 2 (RX).  The flow
+// control and modem line tests also need RTS tied to CTS (pin 7 to pin 8)
+// and are skipped unless GO232_LOOPBACK_RTSCTS is set as well.
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openLoopback opens the looped back port named by GO232_LOOPBACK using the
+// settings in port, skipping the test if the variable isn't set.  With
+// rtscts the test is also skipped unless RTS is wired to CTS.  The port is
+// closed when the test ends.
+func openLoopback(t *testing.T, port *Serial, rtscts bool) {
+	t.Helper()
+
+	name := os.Getenv("GO232_LOOPBACK")
+	if "" == name {
+		t.Skip("GO232_LOOPBACK is not set")
+	}
+	if rtscts && "" == os.Getenv("GO232_LOOPBACK_RTSCTS") {
+		t.Skip("GO232_LOOPBACK_RTSCTS is not set")
+	}
+
+	port.Name = name
+	if 0 == port.Baud {
+		port.Baud = 9600
+	}
+	if err := port.Open(); nil != err {
+		t.Fatalf("Open('%s') error: %v", name, err)
+	}
+	t.Cleanup(func() { port.Close() })
+
+	if err := port.FlushInput(); nil != err {
+		t.Fatalf("FlushInput() error: %v", err)
+	}
+}
+
+// pattern returns n bytes covering every byte value.
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+
+	return b
+}
+
+func TestLoopbackBauds(t *testing.T) {
+	for _, baud := range []int{1200, 9600, 19200, 57600, 115200, 230400, 250000} {
+		var port Serial
+		port.Baud = baud
+		openLoopback(t, &port, false)
+
+		// Standard rates can be read back from the termios.
+		if constant, ok := BaudConstant(baud); ok {
+			term, err := port.GetTermios()
+			if nil != err {
+				t.Fatalf("GetTermios() at %d error: %v", baud, err)
+			}
+			if constant != term.Cflag&unix.CBAUD {
+				t.Errorf("CBAUD = 0x%x at %d, expected 0x%x", term.Cflag&unix.CBAUD, baud, constant)
+			}
+		}
+
+		sent := pattern(512)
+		writeAllTo(t, &port, sent)
+		if got := readN(t, &port, len(sent)); !bytes.Equal(sent, got) {
+			t.Errorf("at %d baud read % x, expected % x", baud, got, sent)
+		}
+
+		port.Close()
+	}
+}
+
+func TestLoopbackChangeBaud(t *testing.T) {
+	var port Serial
+	openLoopback(t, &port, false)
+
+	for _, baud := range []int{115200, 4800, 38400} {
+		port.Baud = baud
+		if err := port.UpdateCfg(); nil != err {
+			t.Fatalf("UpdateCfg() at %d error: %v", baud, err)
+		}
+
+		sent := []byte("go232 loopback")
+		writeAllTo(t, &port, sent)
+		if got := readN(t, &port, len(sent)); !bytes.Equal(sent, got) {
+			t.Errorf("after changing to %d baud read %q, expected %q", baud, got, sent)
+		}
+	}
+}
+
+func TestLoopbackBreak(t *testing.T) {
+	port := Serial{Break: BreakAsNUL}
+	openLoopback(t, &port, false)
+
+	writeAllTo(t, &port, []byte("ab"))
+	if err := port.SendBreak(); nil != err {
+		t.Fatalf("SendBreak() error: %v", err)
+	}
+
+	// The break is read as a NUL after the data sent ahead of it.
+	if got := readN(t, &port, 3); "ab\x00" != string(got) {
+		t.Fatalf("read %q, expected \"ab\\x00\"", got)
+	}
+}
+
+func TestLoopbackModemLines(t *testing.T) {
+	var port Serial
+	openLoopback(t, &port, true)
+
+	for _, on := range []bool{false, true, false} {
+		if err := port.SetRTS(on); nil != err {
+			t.Fatalf("SetRTS(%v) error: %v", on, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		cts, err := port.CTS()
+		if nil != err {
+			t.Fatalf("CTS() error: %v", err)
+		}
+		if on != cts {
+			t.Errorf("CTS() = %v after SetRTS(%v)", cts, on)
+		}
+	}
+}
+
+func TestLoopbackFlowControl(t *testing.T) {
+	port := Serial{RTSCTS: true}
+	openLoopback(t, &port, true)
+
+	// With CTS deasserted the output must be held off.
+	if err := port.SetRTS(false); nil != err {
+		t.Fatalf("SetRTS(false) error: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	active, err := port.FlowControlActive()
+	if nil != err {
+		t.Fatalf("FlowControlActive() error: %v", err)
+	}
+	if !active {
+		t.Fatalf("FlowControlActive() = false with CTS deasserted")
+	}
+
+	if err := port.SetRTS(true); nil != err {
+		t.Fatalf("SetRTS(true) error: %v", err)
+	}
+	if err := port.FlushInput(); nil != err {
+		t.Fatalf("FlushInput() error: %v", err)
+	}
+
+	sent := pattern(256)
+	writeAllTo(t, &port, sent)
+	if got := readN(t, &port, len(sent)); !bytes.Equal(sent, got) {
+		t.Errorf("with CTS asserted read % x, expected % x", got, sent)
+	}
+}
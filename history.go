@@ -0,0 +1,89 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+// history keeps the last bytes put into it in a fixed size ring.
+type history struct {
+	buf   []byte
+	start int
+	count int
+}
+
+func (h *history) put(b []byte) {
+	if 0 == len(h.buf) {
+		return
+	}
+	if len(h.buf) < len(b) {
+		b = b[len(b)-len(h.buf):]
+	}
+
+	for _, v := range b {
+		if h.count == len(h.buf) {
+			h.start = (h.start + 1) % len(h.buf)
+			h.count--
+		}
+		h.buf[(h.start+h.count)%len(h.buf)] = v
+		h.count++
+	}
+}
+
+// bytes returns a copy of the bytes in the ring, oldest first.
+func (h *history) bytes() []byte {
+	out := make([]byte, h.count)
+	for i := range out {
+		out[i] = h.buf[(h.start+i)%len(h.buf)]
+	}
+
+	return out
+}
+
+// SetHistorySize keeps the last n bytes read and written in memory, so the
+// traffic right before a failure can be inspected with History.  Zero, the
+// default, turns the history off.  Changing the size discards the history.
+func (s *Serial) SetHistorySize(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	s.hmu.Lock()
+	defer s.hmu.Unlock()
+
+	s.histIn = history{buf: make([]byte, n)}
+	s.histOut = history{buf: make([]byte, n)}
+	s.histOn.Store(0 < n)
+}
+
+// History returns the last bytes read from and written to the port, up to
+// the size set by SetHistorySize, oldest first.
+func (s *Serial) History() (in, out []byte) {
+	s.hmu.Lock()
+	defer s.hmu.Unlock()
+
+	return s.histIn.bytes(), s.histOut.bytes()
+}
+
+// record adds the traffic to the history, if it is on.
+func (s *Serial) record(h *history, b []byte) {
+	if !s.histOn.Load() {
+		return
+	}
+
+	s.hmu.Lock()
+	h.put(b)
+	s.hmu.Unlock()
+}
@@ -0,0 +1,73 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// LockFlock takes an advisory exclusive lock on the port with flock(2),
+// the convention some tools use to coordinate access to a device.  If
+// another open file of the port holds the lock it fails right away.  The
+// lock is released by UnlockFlock or when the port is closed.  See
+// SetExclusive for the kernel enforced alternative.
+func (s *Serial) LockFlock() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	err := unix.Flock(int(s.fd), unix.LOCK_EX|unix.LOCK_NB)
+	if unix.EWOULDBLOCK == err {
+		return fmt.Errorf("Serial port '%s' is already locked: %w", s.Name, err)
+	}
+
+	return err
+}
+
+// UnlockFlock releases the lock taken by LockFlock.
+func (s *Serial) UnlockFlock() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	return unix.Flock(int(s.fd), unix.LOCK_UN)
+}
+
+// SetExclusive puts the port in exclusive mode (TIOCEXCL), in which the
+// kernel refuses further opens of the tty except by root, or turns it off
+// again (TIOCNXCL).  Exclusive mode ends once every open file of the tty
+// is closed.
+func (s *Serial) SetExclusive(exclusive bool) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	req := unix.TIOCNXCL
+	if exclusive {
+		req = unix.TIOCEXCL
+	}
+
+	errno := s.ioctl(uintptr(req), 0)
+	if 0 != errno {
+		return errno
+	}
+
+	return nil
+}
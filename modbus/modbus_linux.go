@@ -0,0 +1,104 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package modbus provides Modbus RTU framing on top of a go232 serial port.
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/schmidtw/go232"
+	"github.com/schmidtw/go232/crc"
+)
+
+// ErrCRC is returned when a received RTU frame fails its CRC check.
+var ErrCRC = errors.New("Invalid Modbus RTU frame CRC.")
+
+// FrameGap returns the silent interval of 3.5 character times that
+// separates RTU frames at the port's current baud rate and configuration.
+// Above 19200 baud the Modbus specification fixes the interval at 1.75ms.
+func FrameGap(s *go232.Serial) (time.Duration, error) {
+	if 19200 < s.Baud {
+		return 1750 * time.Microsecond, nil
+	}
+
+	c, err := s.ByteDuration()
+	if nil != err {
+		return 0, err
+	}
+
+	return c * 7 / 2, nil
+}
+
+// WriteRTUFrame appends the CRC to the frame (address, function code and
+// data) and writes it to the port.  It returns once the frame has been
+// transmitted and the inter-frame gap has passed, so the next frame written
+// is correctly delimited.
+func WriteRTUFrame(s *go232.Serial, frame []byte) error {
+	gap, err := FrameGap(s)
+	if nil != err {
+		return err
+	}
+
+	sum := crc.CRC16Modbus(frame)
+	adu := append(append([]byte{}, frame...), byte(sum), byte(sum>>8))
+	for 0 < len(adu) {
+		n, err := s.Write(adu)
+		if nil != err {
+			return err
+		}
+		adu = adu[n:]
+	}
+
+	if err := s.Drain(); nil != err {
+		return err
+	}
+	time.Sleep(gap)
+
+	return nil
+}
+
+// ReadRTUFrame waits up to timeout for an RTU frame, delimited by the
+// inter-frame gap, and returns it after verifying and removing the CRC.
+// USB adapters that buffer received data for longer than the gap will
+// merge or split frames, so their latency should be tuned accordingly.
+func ReadRTUFrame(s *go232.Serial, timeout time.Duration) ([]byte, error) {
+	gap, err := FrameGap(s)
+	if nil != err {
+		return nil, err
+	}
+
+	adu, err := s.ReadFrame(gap, timeout)
+	if nil != err {
+		return nil, err
+	}
+
+	// The smallest frame is an address, a function code and the CRC.
+	if len(adu) < 4 {
+		return nil, fmt.Errorf("Modbus RTU frame too short: %d bytes.", len(adu))
+	}
+
+	frame := adu[:len(adu)-2]
+	sum := crc.CRC16Modbus(frame)
+	if byte(sum) != adu[len(adu)-2] || byte(sum>>8) != adu[len(adu)-1] {
+		return nil, ErrCRC
+	}
+
+	return frame, nil
+}
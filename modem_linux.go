@@ -0,0 +1,220 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultResetPulse is how long ResetViaDTR holds DTR deasserted when no
+// pulse duration is given.
+const DefaultResetPulse = 100 * time.Millisecond
+
+// ResetStep is a single step of a reset sequence: the state to drive the
+// DTR and RTS lines to and how long to hold that state.
+type ResetStep struct {
+	DTR  bool          // Assert DTR during this step
+	RTS  bool          // Assert RTS during this step
+	Hold time.Duration // How long to hold the lines before the next step
+}
+
+// setModemBits asserts (TIOCMBIS) or deasserts (TIOCMBIC) the modem lines.
+func (s *Serial) setModemBits(bits int, on bool) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	req := unix.TIOCMBIC
+	if on {
+		req = unix.TIOCMBIS
+	}
+
+	b := int32(bits)
+	errno := s.ioctl(uintptr(req), uintptr(unsafe.Pointer(&b)))
+	if 0 != errno {
+		return errno
+	}
+
+	return nil
+}
+
+// SetDTR asserts or deasserts the DTR (data terminal ready) line.
+func (s *Serial) SetDTR(on bool) error {
+	return s.setModemBits(unix.TIOCM_DTR, on)
+}
+
+// SetRTS asserts or deasserts the RTS (request to send) line.
+func (s *Serial) SetRTS(on bool) error {
+	return s.setModemBits(unix.TIOCM_RTS, on)
+}
+
+// setDTRRTS drives both the DTR and RTS lines at the same time.
+func (s *Serial) setDTRRTS(dtr, rts bool) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	var bits int32
+	errno := s.ioctl(uintptr(unix.TIOCMGET), uintptr(unsafe.Pointer(&bits)))
+	if 0 != errno {
+		return errno
+	}
+
+	bits &^= unix.TIOCM_DTR | unix.TIOCM_RTS
+	if dtr {
+		bits |= unix.TIOCM_DTR
+	}
+	if rts {
+		bits |= unix.TIOCM_RTS
+	}
+
+	errno = s.ioctl(uintptr(unix.TIOCMSET), uintptr(unsafe.Pointer(&bits)))
+	if 0 != errno {
+		return errno
+	}
+
+	return nil
+}
+
+// Hangup signals a modem to disconnect by deasserting DTR and RTS together,
+// the soft line-drop a modem treats as the end of the call.  The port
+// stays open and usable, and the lines can be asserted again with SetDTR
+// and SetRTS.  See VHangup for a full hangup of the tty.
+func (s *Serial) Hangup() error {
+	return s.setDTRRTS(false, false)
+}
+
+// VHangup performs a full hangup of the tty (TIOCVHANGUP), like vhangup(2):
+// every open file of the tty, including this one, is hung up so further
+// reads and writes fail until the port is closed and opened again.  It
+// requires CAP_SYS_ADMIN.
+func (s *Serial) VHangup() error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	errno := s.ioctl(uintptr(unix.TIOCVHANGUP), 0)
+	if 0 != errno {
+		return errno
+	}
+
+	return nil
+}
+
+// modemBits returns the state of the modem lines (TIOCMGET).
+func (s *Serial) modemBits() (int, error) {
+	if nil == s.file {
+		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	var bits int32
+	errno := s.ioctl(uintptr(unix.TIOCMGET), uintptr(unsafe.Pointer(&bits)))
+	if 0 != errno {
+		return 0, errno
+	}
+
+	return int(bits), nil
+}
+
+// CTS reports if the CTS (clear to send) line is asserted by the peer.
+func (s *Serial) CTS() (bool, error) {
+	bits, err := s.modemBits()
+
+	return 0 != bits&unix.TIOCM_CTS, err
+}
+
+// flowProbeSize is the size of the burst FlowControlActive sends, larger
+// than the transmit FIFO of common UARTs.
+const flowProbeSize = 256
+
+// FlowControlActive probes whether hardware flow control actually holds off
+// the output, to detect missing CTS wiring.  The peer must keep CTS
+// deasserted during the probe; if CTS is asserted nothing can be learned
+// and an error is returned.  The probe writes a burst of zero bytes, waits
+// long enough for them to be sent at the configured baud rate and reports
+// whether any are still queued (TIOCOUTQ).  Whatever is left is discarded.
+// If flow control isn't working the burst reaches the peer, so only probe
+// peers that tolerate it.  Without RTSCTS the result is always false.
+func (s *Serial) FlowControlActive() (bool, error) {
+	cts, err := s.CTS()
+	if nil != err {
+		return false, err
+	}
+	if cts {
+		return false, fmt.Errorf("Serial port '%s' has CTS asserted, the peer must deassert it for the probe.", s.Name)
+	}
+	if !s.RTSCTS {
+		return false, nil
+	}
+
+	d, err := s.ByteDuration()
+	if nil != err {
+		return false, err
+	}
+
+	if _, err := s.Write(make([]byte, flowProbeSize)); nil != err {
+		return false, err
+	}
+	time.Sleep(2*flowProbeSize*d + 20*time.Millisecond)
+
+	waiting, err := s.OutputWaiting()
+	if nil != err {
+		return false, err
+	}
+
+	errno := s.ioctl(uintptr(unix.TCFLSH), uintptr(unix.TCOFLUSH))
+	if 0 != errno {
+		return false, errno
+	}
+
+	return 0 < waiting, nil
+}
+
+// ResetViaDTR resets a device, Arduino style, by deasserting DTR for the
+// pulse duration and then asserting it again.  A zero pulse uses
+// DefaultResetPulse.
+func (s *Serial) ResetViaDTR(pulse time.Duration) error {
+	if 0 == pulse {
+		pulse = DefaultResetPulse
+	}
+
+	if err := s.SetDTR(false); nil != err {
+		return err
+	}
+	time.Sleep(pulse)
+
+	return s.SetDTR(true)
+}
+
+// ResetSequence drives the DTR and RTS lines through each step in order,
+// for boards that need a specific pattern such as the RTS then DTR sequence
+// used to enter the ESP32 bootloader.
+func (s *Serial) ResetSequence(steps []ResetStep) error {
+	for _, step := range steps {
+		if err := s.setDTRRTS(step.DTR, step.RTS); nil != err {
+			return err
+		}
+		time.Sleep(step.Hold)
+	}
+
+	return nil
+}
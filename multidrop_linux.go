@@ -0,0 +1,128 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// addrb is the termios ADDRB flag from Linux 6.0, which x/sys doesn't
+// define yet.  It comes from asm-generic/termbits-common.h so it has the
+// same value on every architecture.
+const addrb = 0x20000000
+
+// SetMultidrop turns the 9-bit addressing mode (ADDRB) of RS-485 multidrop
+// buses on or off and applies it if the port is open.  ADDRB needs Linux
+// 6.0 or newer and a UART driver that implements it; the kernel clears the
+// flag for drivers that don't, which is reported as ErrNotSupported.  On
+// those ports WriteAddress, which uses mark and space parity instead, can
+// usually be used.
+func (s *Serial) SetMultidrop(enable bool) error {
+	s.Multidrop = enable
+	if nil == s.file {
+		return nil
+	}
+
+	if err := s.UpdateCfg(); nil != err {
+		return err
+	}
+	if !enable {
+		return nil
+	}
+
+	t, err := s.GetTermios()
+	if nil != err {
+		return err
+	}
+	if 0 == t.Cflag&addrb {
+		s.Multidrop = false
+		return fmt.Errorf("Serial port '%s' does not support 9-bit addressing: %w", s.Name, ErrNotSupported)
+	}
+
+	return nil
+}
+
+// WriteAddress sends an address byte on a 9-bit multidrop bus.  The byte is
+// sent with mark parity so the parity bit acts as a set 9th bit, then the
+// configured parity is restored.  Data bytes can either be sent with
+// WriteData, or with Write when Config uses space parity, such as '8S1', so
+// they go out with the 9th bit clear.
+//
+// The output is drained before and after the byte since changing the
+// parity while a character is still being shifted out would corrupt it on
+// the wire.  Each parity change therefore leaves a gap on the line of at
+// least the time the kernel and UART take to drain and reconfigure, which
+// is typically around a millisecond and more with USB adapters.  Peers that
+// enforce a short inter-byte timeout within a frame may reject the frame.
+func (s *Serial) WriteAddress(addr byte) error {
+	return s.writeParity([]byte{addr}, 'M')
+}
+
+// WriteData sends data bytes on a 9-bit multidrop bus with space parity so
+// the parity bit acts as a clear 9th bit, whatever parity Config uses, and
+// then restores the configured parity.  It has the same drain and timing
+// requirements as WriteAddress.
+func (s *Serial) WriteData(data []byte) error {
+	return s.writeParity(data, 'S')
+}
+
+// writeParity sends b with the parity forced to mark or space, draining the
+// output around the parity changes.  Once the parity has been forced the
+// original termios is restored however the write ends.
+func (s *Serial) writeParity(b []byte, parity byte) (err error) {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if s.ReadOnly {
+		return fmt.Errorf("Serial port '%s' is open read only.", s.Name)
+	}
+
+	t, err := s.buildTermios()
+	if nil != err {
+		return err
+	}
+
+	forced := t
+	forced.Cflag &^= unix.PARENB | unix.PARODD | unix.CMSPAR
+	forced.Cflag |= parityMap[parity]
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	if err := s.Drain(); nil != err {
+		return err
+	}
+	if err := s.applyTermios(forced); nil != err {
+		return err
+	}
+	defer func() {
+		if rerr := s.applyTermios(t); nil != rerr {
+			err = errors.Join(err, rerr)
+		}
+	}()
+
+	if _, err := s.file.Write(b); nil != err {
+		return err
+	}
+	s.sent(b)
+
+	return s.Drain()
+}
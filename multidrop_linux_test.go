@@ -0,0 +1,60 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import "testing"
+
+func TestWriteParityRestores(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	before, err := port.GetTermios()
+	if nil != err {
+		t.Fatalf("GetTermios() error: %v", err)
+	}
+
+	if err := port.WriteAddress(0x42); nil != err {
+		t.Fatalf("WriteAddress() error: %v", err)
+	}
+	if err := port.WriteData([]byte{0x01, 0x02}); nil != err {
+		t.Fatalf("WriteData() error: %v", err)
+	}
+	if got := readN(t, master, 3); "\x42\x01\x02" != string(got) {
+		t.Fatalf("master read % x, expected 42 01 02", got)
+	}
+
+	after, err := port.GetTermios()
+	if nil != err {
+		t.Fatalf("GetTermios() error: %v", err)
+	}
+	if before.Cflag != after.Cflag {
+		t.Fatalf("Cflag = 0x%x after the writes, expected 0x%x", after.Cflag, before.Cflag)
+	}
+}
+
+func TestWriteParityReadOnly(t *testing.T) {
+	port := Serial{ReadOnly: true}
+	openPair(t, &port)
+
+	if err := port.WriteAddress(0x42); nil == err {
+		t.Fatalf("WriteAddress() on a read only port succeeded")
+	}
+	if err := port.WriteData([]byte{0x01}); nil == err {
+		t.Fatalf("WriteData() on a read only port succeeded")
+	}
+}
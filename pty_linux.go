@@ -0,0 +1,66 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptyBaud is the baud rate the master of a pseudo-terminal is configured
+// with.  Pseudo-terminals accept any rate and ignore it.
+const ptyBaud = 38400
+
+// OpenPTYPair creates a pseudo-terminal and returns its master side as an
+// open Serial in raw mode, along with the path of the slave side, such as
+// "/dev/pts/3".  Whatever is written to the master can be read from the
+// slave and the other way round, so a program talking to the slave sees a
+// virtual serial device implemented on the master, for example as a test
+// fixture or an emulator.  The slave has the kernel's default settings,
+// which its user is expected to configure.
+func OpenPTYPair() (master *Serial, slaveName string, err error) {
+	f, err := os.OpenFile("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if nil != err {
+		return nil, "", err
+	}
+
+	var n uint32
+	errno := ioctl(f.Fd(), uintptr(unix.TIOCGPTN), uintptr(unsafe.Pointer(&n)))
+	if 0 != errno {
+		f.Close()
+		return nil, "", fmt.Errorf("ioctl( '%s', TIOCGPTN, &n ) error: %w", f.Name(), errno)
+	}
+
+	var unlock int32
+	errno = ioctl(f.Fd(), uintptr(unix.TIOCSPTLCK), uintptr(unsafe.Pointer(&unlock)))
+	if 0 != errno {
+		f.Close()
+		return nil, "", fmt.Errorf("ioctl( '%s', TIOCSPTLCK, &unlock ) error: %w", f.Name(), errno)
+	}
+
+	master = &Serial{Baud: ptyBaud}
+	if err := master.OpenFromFile(f); nil != err {
+		f.Close()
+		return nil, "", err
+	}
+
+	return master, fmt.Sprintf("/dev/pts/%d", n), nil
+}
@@ -0,0 +1,287 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// testTimeout bounds every wait in the pty tests so a regression fails the
+// test instead of hanging it.
+const testTimeout = 5 * time.Second
+
+// openPair opens a pseudo-terminal pair for a test: the slave is opened
+// using the settings in port, with Name set to the slave path, and the
+// master is returned as the controllable peer.  Both are closed when the
+// test ends.
+func openPair(t testing.TB, port *Serial) *Serial {
+	t.Helper()
+
+	master, name, err := OpenPTYPair()
+	if nil != err {
+		t.Fatalf("OpenPTYPair() error: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	port.Name = name
+	if 0 == port.Baud {
+		port.Baud = 9600
+	}
+	if err := port.Open(); nil != err {
+		t.Fatalf("Open('%s') error: %v", name, err)
+	}
+	t.Cleanup(func() { port.Close() })
+
+	return master
+}
+
+// readN reads exactly n bytes from the port, failing the test if they
+// don't arrive within testTimeout.
+func readN(t testing.TB, s *Serial, n int) []byte {
+	t.Helper()
+
+	s.SetReadDeadline(time.Now().Add(testTimeout))
+	defer s.SetReadDeadline(time.Time{})
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s, b); nil != err {
+		t.Fatalf("reading %d bytes from '%s': %v", n, s.Name, err)
+	}
+
+	return b
+}
+
+// writeAllTo writes all of b to the port, failing the test on an error.
+func writeAllTo(t testing.TB, s *Serial, b []byte) {
+	t.Helper()
+
+	if err := writeAll(s, b); nil != err {
+		t.Fatalf("writing to '%s': %v", s.Name, err)
+	}
+}
+
+func TestOpenPTYPair(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	for _, s := range []*Serial{master, &port} {
+		tty, err := IsSerialPort(s.Name)
+		if nil != err || !tty {
+			t.Fatalf("IsSerialPort('%s') = %v, %v", s.Name, tty, err)
+		}
+		if ^uintptr(0) == s.Fd() {
+			t.Fatalf("Fd() of '%s' is invalid", s.Name)
+		}
+	}
+
+	if err := port.Open(); nil == err {
+		t.Fatalf("Open() of an open port succeeded")
+	}
+}
+
+func TestOpenPTYPairIndependent(t *testing.T) {
+	var first, second Serial
+	m1 := openPair(t, &first)
+	m2 := openPair(t, &second)
+
+	if first.Name == second.Name {
+		t.Fatalf("both pairs have the slave '%s'", first.Name)
+	}
+
+	// The master is raw, so nothing is echoed back or translated.
+	term, err := m1.GetTermios()
+	if nil != err {
+		t.Fatalf("GetTermios() of the master error: %v", err)
+	}
+	if 0 != term.Lflag&(unix.ICANON|unix.ECHO) || 0 != term.Oflag&unix.OPOST {
+		t.Fatalf("master termios Lflag 0x%x, Oflag 0x%x, expected raw", term.Lflag, term.Oflag)
+	}
+
+	writeAllTo(t, m1, []byte("one\r\n"))
+	writeAllTo(t, m2, []byte("two\r\n"))
+	if got := readN(t, &second, 5); "two\r\n" != string(got) {
+		t.Fatalf("second slave read %q, expected \"two\\r\\n\"", got)
+	}
+	if got := readN(t, &first, 5); "one\r\n" != string(got) {
+		t.Fatalf("first slave read %q, expected \"one\\r\\n\"", got)
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	s := Serial{Name: "/dev/go232-does-not-exist", Baud: 9600}
+
+	if err := s.Open(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Open() = %v, expected os.ErrNotExist", err)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	// Every byte value passes through unchanged in raw mode.
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	writeAllTo(t, &port, data)
+	if got := readN(t, master, len(data)); !bytes.Equal(data, got) {
+		t.Fatalf("master read % x, expected % x", got, data)
+	}
+
+	writeAllTo(t, master, data)
+	if got := readN(t, &port, len(data)); !bytes.Equal(data, got) {
+		t.Fatalf("port read % x, expected % x", got, data)
+	}
+}
+
+func TestReadDeadlineExceeded(t *testing.T) {
+	var port Serial
+	openPair(t, &port)
+
+	port.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := port.Read(make([]byte, 1))
+	if 0 != n || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read() = %d, %v, expected os.ErrDeadlineExceeded", n, err)
+	}
+}
+
+func TestChangeBaud(t *testing.T) {
+	tests := []struct {
+		description string
+		baud        int
+	}{
+		{description: "standard rate", baud: 115200},
+		{description: "slow rate", baud: 300},
+		{description: "custom rate", baud: 250000},
+	}
+
+	var port Serial
+	openPair(t, &port)
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			port.Baud = tc.baud
+			if err := port.UpdateCfg(); nil != err {
+				t.Fatalf("UpdateCfg() error: %v", err)
+			}
+
+			_, input, output, err := port.EffectiveBaud()
+			if nil != err {
+				t.Fatalf("EffectiveBaud() error: %v", err)
+			}
+			if tc.baud != input || tc.baud != output {
+				t.Fatalf("EffectiveBaud() = %d in, %d out, expected %d", input, output, tc.baud)
+			}
+		})
+	}
+}
+
+func TestFlushInput(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	writeAllTo(t, master, []byte("stale"))
+	waitInput(t, &port, 5)
+
+	if err := port.FlushInput(); nil != err {
+		t.Fatalf("FlushInput() error: %v", err)
+	}
+	if n, err := port.InputWaiting(); nil != err || 0 != n {
+		t.Fatalf("InputWaiting() after FlushInput() = %d, %v", n, err)
+	}
+
+	// Input arriving after the flush is kept.
+	writeAllTo(t, master, []byte("fresh"))
+	if got := readN(t, &port, 5); "fresh" != string(got) {
+		t.Fatalf("Read() = %q, expected \"fresh\"", got)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	writeAllTo(t, master, []byte("stale"))
+	waitInput(t, &port, 5)
+
+	if err := port.Flush(); nil != err {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	if n, err := port.InputWaiting(); nil != err || 0 != n {
+		t.Fatalf("InputWaiting() after Flush() = %d, %v", n, err)
+	}
+}
+
+// waitInput waits until at least n bytes are waiting to be read.
+func waitInput(t *testing.T, s *Serial, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		waiting, err := s.InputWaiting()
+		if nil != err {
+			t.Fatalf("InputWaiting() error: %v", err)
+		}
+		if n <= waiting {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d bytes arrived", waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSendBreak(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	if err := port.SendBreak(); nil != err {
+		t.Fatalf("SendBreak() error: %v", err)
+	}
+
+	// The port keeps working after the break.
+	writeAllTo(t, &port, []byte("after"))
+	if got := readN(t, master, 5); "after" != string(got) {
+		t.Fatalf("master read %q, expected \"after\"", got)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	var port Serial
+	openPair(t, &port)
+
+	if err := port.Close(); nil != err {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if err := port.Close(); nil != err {
+		t.Fatalf("second Close() error: %v", err)
+	}
+	if _, err := port.Write([]byte{0}); nil == err {
+		t.Fatalf("Write() after Close() succeeded")
+	}
+}
@@ -0,0 +1,193 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// StartPump starts a goroutine that continuously reads the port into an
+// internal queue of up to queueBytes, decoupling the reader from the timing
+// of the input.  Read and the timed read helpers then serve the queue: Read
+// waits until at least one byte is queued, up to the read deadline or
+// timeout, so Vmin and Vtime no longer apply.  Nothing is ever dropped: a
+// full queue pauses the pump until it is read from, leaving the input to
+// the kernel and any flow control.  A read error stops the pump and is
+// returned once the queue is empty.  The queue must hold the largest line
+// or frame the timed read helpers wait for, or they can't complete.
+func (s *Serial) StartPump(queueBytes int) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if queueBytes < 1 {
+		return fmt.Errorf("Invalid pump queue size: %d", queueBytes)
+	}
+
+	p := &pump{
+		limit: queueBytes,
+		data:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nil != s.pump {
+		return fmt.Errorf("Serial port '%s' already has a pump.", s.Name)
+	}
+	s.pump = p
+
+	go s.runPump(p)
+
+	return nil
+}
+
+// StopPump stops the pump started by StartPump and waits for it to exit.
+// The input already queued is still returned by Read.
+func (s *Serial) StopPump() {
+	s.mu.Lock()
+	p := s.pump
+	s.pump = nil
+	s.mu.Unlock()
+
+	if nil != p {
+		close(p.stop)
+		<-p.done
+	}
+}
+
+func (s *Serial) currentPump() *pump {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pump
+}
+
+func (s *Serial) runPump(p *pump) {
+	defer close(p.done)
+
+	buf := make([]byte, 4096)
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		s.mu.Lock()
+		space := p.limit - len(s.rbuf)
+		s.mu.Unlock()
+		if space <= 0 {
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			continue
+		}
+
+		// Wait for data in short intervals so StopPump is noticed promptly.
+		ready, err := s.poll(unix.POLLIN, time.Now().Add(100*time.Millisecond))
+		if nil == err && !ready {
+			continue
+		}
+
+		var n int
+		if nil == err {
+			n, err = s.readAvailable(buf[:min(space, len(buf))])
+		}
+
+		s.mu.Lock()
+		s.rbuf = append(s.rbuf, buf[:n]...)
+		p.err = err
+		s.mu.Unlock()
+
+		select {
+		case p.data <- struct{}{}:
+		default:
+		}
+
+		if nil != err {
+			return
+		}
+	}
+}
+
+// wait waits until the pump queues more input, changed is closed or the
+// deadline passes, in which case os.ErrDeadlineExceeded is returned.  A zero
+// deadline waits forever and a nil changed is never closed.
+func (p *pump) wait(s *Serial, deadline time.Time, changed <-chan struct{}) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-p.data:
+		return nil
+	case <-changed:
+		return nil
+	case <-p.done:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return p.err
+}
+
+// readPump is Read while the pump is running.  If the pump is stopped
+// while it waits, Read continues without it.
+func (s *Serial) readPump(p *pump, b []byte) (int, error) {
+	timeout := s.deadlineFor(s.readTimeout)
+
+	for {
+		if err := s.closedErr(); nil != err {
+			return 0, err
+		}
+
+		s.mu.Lock()
+		buf := s.buffered()
+		if 0 < len(buf) {
+			n := copy(b, buf)
+			s.rbuf = buf[n:]
+			s.mu.Unlock()
+			return n, nil
+		}
+		running := p == s.pump
+		s.mu.Unlock()
+
+		if !running {
+			return s.read(b)
+		}
+
+		changed := s.deadlineChanged()
+		if err := p.wait(s, earliest(s.getReadDeadline(), timeout), changed); nil != err {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// isDisconnect reports if the error means the device behind the port went
+// away.
+func isDisconnect(err error) bool {
+	return errors.Is(err, unix.EIO) || errors.Is(err, unix.ENODEV) || errors.Is(err, unix.ENXIO)
+}
+
+func (s *Serial) isReconnecting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reconnecting
+}
+
+func (s *Serial) reconnectingErr() error {
+	return fmt.Errorf("Serial port '%s' is reconnecting: %w", s.Name, ErrReconnecting)
+}
+
+// checkDisconnect starts reconnecting in the background if AutoReconnect is
+// enabled and the error means the device went away.  It returns the error
+// to report to the caller.
+func (s *Serial) checkDisconnect(err error) error {
+	if nil == err || 0 == s.AutoReconnect || !isDisconnect(err) {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.reconnecting {
+		s.mu.Unlock()
+		return s.reconnectingErr()
+	}
+	s.reconnecting = true
+	s.mu.Unlock()
+
+	if f := s.detachFile(); nil != f {
+		f.Close()
+	}
+	s.logOp("disconnect", err)
+	s.closed(err)
+
+	go s.reconnect()
+
+	return s.reconnectingErr()
+}
+
+// reconnect tries to reopen and reconfigure the port every AutoReconnect
+// until it succeeds or the port is closed.
+func (s *Serial) reconnect() {
+	for {
+		time.Sleep(s.AutoReconnect)
+		if !s.isReconnecting() {
+			return
+		}
+
+		f, err := s.openFile()
+		if nil != err {
+			s.logOp("reconnect", err)
+			continue
+		}
+
+		s.mu.Lock()
+		if !s.reconnecting {
+			s.mu.Unlock()
+			f.Close()
+			return
+		}
+		s.file = f
+		s.fd = f.Fd()
+		s.mu.Unlock()
+
+		if err := s.setup(); nil != err {
+			s.logOp("reconnect", err)
+			s.mu.Lock()
+			s.file = nil
+			s.mu.Unlock()
+			f.Close()
+			continue
+		}
+
+		s.mu.Lock()
+		s.reconnecting = false
+		s.mu.Unlock()
+		s.logOp("reconnect", nil)
+
+		return
+	}
+}
+
+// Alive reports whether the port is still usable, to notice a device that
+// went away before the next Read or Write fails.  It probes the port with a
+// TCGETS, which doesn't change anything, and treats any error as dead, such
+// as the ones a hung up or removed tty returns (EIO, ENODEV, ENXIO) or
+// EBADF once the file was closed.  A port that is closed or reconnecting
+// isn't alive.  With AutoReconnect a port that went away starts
+// reconnecting, just like a failed Read would.
+func (s *Serial) Alive() bool {
+	if nil == s.file || s.isReconnecting() {
+		return false
+	}
+
+	var t unix.Termios
+	errno := s.ioctl(uintptr(unix.TCGETS), uintptr(unsafe.Pointer(&t)))
+	if 0 == errno {
+		return true
+	}
+
+	s.checkDisconnect(errno)
+
+	return false
+}
+
+// stopReconnect stops a background reconnect, if one is running.
+func (s *Serial) stopReconnect() {
+	s.mu.Lock()
+	s.reconnecting = false
+	s.mu.Unlock()
+}
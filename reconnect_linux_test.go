@@ -0,0 +1,83 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDisconnectStopsPumpAndWatchdog(t *testing.T) {
+	port := Serial{AutoReconnect: time.Hour}
+	master := openPair(t, &port)
+
+	if err := port.StartPump(1024); nil != err {
+		t.Fatalf("StartPump() error: %v", err)
+	}
+	if err := port.WatchdogReapply(time.Millisecond); nil != err {
+		t.Fatalf("WatchdogReapply() error: %v", err)
+	}
+
+	// Closing the master hangs up the slave, so the Write fails with EIO.
+	master.Close()
+	if _, err := port.Write([]byte{0}); !errors.Is(err, ErrReconnecting) {
+		t.Fatalf("Write() after the hangup = %v, expected ErrReconnecting", err)
+	}
+
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	if nil != port.pump {
+		t.Errorf("the pump is still running after the disconnect")
+	}
+	if nil != port.watchdog {
+		t.Errorf("the watchdog is still running after the disconnect")
+	}
+}
+
+func TestAliveAfterHangup(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	if !port.Alive() {
+		t.Fatalf("Alive() = false on an open port")
+	}
+
+	master.Close()
+	if port.Alive() {
+		t.Fatalf("Alive() = true after the master side was closed")
+	}
+
+	port.Close()
+	if port.Alive() {
+		t.Fatalf("Alive() = true after Close()")
+	}
+}
+
+func TestAliveReconnects(t *testing.T) {
+	port := Serial{AutoReconnect: time.Hour}
+	master := openPair(t, &port)
+
+	master.Close()
+	if port.Alive() {
+		t.Fatalf("Alive() = true after the master side was closed")
+	}
+	if _, err := port.Read(make([]byte, 1)); !errors.Is(err, ErrReconnecting) {
+		t.Fatalf("Read() after Alive() = %v, expected ErrReconnecting", err)
+	}
+}
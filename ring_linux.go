@@ -0,0 +1,186 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// RingBuffer continuously reads from a serial port in the background into a
+// fixed size ring.  When the ring is full the oldest bytes are discarded and
+// counted so that data loss can be detected.
+type RingBuffer struct {
+	port     *Serial
+	mutex    sync.Mutex
+	buf      []byte
+	start    int
+	count    int
+	dropped  uint64
+	overruns int
+	err      error
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewRingBuffer starts reading the open serial port into a ring buffer of
+// the specified size.  The port should not be read from directly until the
+// ring buffer is closed.
+func NewRingBuffer(s *Serial, size int) (*RingBuffer, error) {
+	if nil == s.file {
+		return nil, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("Invalid ring buffer size: %d", size)
+	}
+
+	r := &RingBuffer{
+		port:    s,
+		buf:     make([]byte, size),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	r.overruns = -1
+	if c, err := s.Counters(); nil == err {
+		r.overruns = c.Overrun + c.BufOverrun
+	}
+
+	go r.run()
+
+	return r, nil
+}
+
+func (r *RingBuffer) run() {
+	defer close(r.stopped)
+
+	tmp := make([]byte, 1024)
+	for {
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+
+		// Wait for data in short intervals so Close is noticed promptly.
+		ready, err := r.port.poll(unix.POLLIN, time.Now().Add(100*time.Millisecond))
+		if nil == err && !ready {
+			continue
+		}
+
+		var n int
+		if nil == err {
+			n, err = r.port.Read(tmp)
+		}
+		if 0 < n {
+			r.put(tmp[:n])
+		}
+
+		if nil != err {
+			r.mutex.Lock()
+			r.err = err
+			r.mutex.Unlock()
+			return
+		}
+	}
+}
+
+func (r *RingBuffer) put(p []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, b := range p {
+		if r.count == len(r.buf) {
+			r.start = (r.start + 1) % len(r.buf)
+			r.count--
+			r.dropped++
+		}
+		r.buf[(r.start+r.count)%len(r.buf)] = b
+		r.count++
+	}
+}
+
+// Read copies buffered bytes into b without waiting for more to arrive.  If
+// the buffer is empty and the background reader has stopped due to an error
+// that error is returned.
+func (r *RingBuffer) Read(b []byte) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for n < len(b) && 0 < r.count {
+		b[n] = r.buf[r.start]
+		r.start = (r.start + 1) % len(r.buf)
+		r.count--
+		n++
+	}
+
+	if 0 == n {
+		return 0, r.err
+	}
+
+	return n, nil
+}
+
+// Buffered returns the number of bytes waiting to be read.
+func (r *RingBuffer) Buffered() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.count
+}
+
+// Dropped returns the number of bytes discarded because the ring was full.
+func (r *RingBuffer) Dropped() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.dropped
+}
+
+// Overflowed reports if any data has been lost, either because the ring was
+// full or because the driver's overrun counters increased since the ring
+// buffer was started.  The driver counters are only checked when the driver
+// supports them.
+func (r *RingBuffer) Overflowed() bool {
+	if 0 < r.Dropped() {
+		return true
+	}
+
+	if 0 <= r.overruns {
+		if c, err := r.port.Counters(); nil == err {
+			return r.overruns < c.Overrun+c.BufOverrun
+		}
+	}
+
+	return false
+}
+
+// Close stops the background reader.
+func (r *RingBuffer) Close() error {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	<-r.stopped
+
+	return nil
+}
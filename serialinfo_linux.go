@@ -0,0 +1,239 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ClosingWaitForever is the closing wait that makes close wait for pending
+// output to drain without any limit.
+const ClosingWaitForever time.Duration = -1
+
+// The special closing_wait values used by the kernel.
+const (
+	closingWaitNone = 65535
+	closingWaitInf  = 0
+)
+
+// serialStruct mirrors the kernel's struct serial_struct.
+type serialStruct struct {
+	Type          int32
+	Line          int32
+	Port          uint32
+	Irq           int32
+	Flags         int32
+	XmitFifoSize  int32
+	CustomDivisor int32
+	BaudBase      int32
+	CloseDelay    uint16
+	IoType        int8
+	ReservedChar  int8
+	Hub6          int32
+	ClosingWait   uint16
+	ClosingWait2  uint16
+	IomemBase     uintptr
+	IomemRegShift uint16
+	PortHigh      uint32
+	IomapBase     uintptr
+}
+
+// serialErr converts the errno from a TIOCGSERIAL/TIOCSSERIAL request into
+// an error, reporting drivers without support as ErrNotSupported.
+func (s *Serial) serialErr(req string, errno unix.Errno) error {
+	switch errno {
+	case 0:
+		return nil
+	case unix.ENOTTY, unix.EINVAL, unix.ENOSYS:
+		return fmt.Errorf("Serial port '%s' does not support %s: %w", s.Name, req, ErrNotSupported)
+	}
+
+	return fmt.Errorf("ioctl( '%s', %s, &ss ) error: %w", s.Name, req, errno)
+}
+
+func (s *Serial) getSerial() (serialStruct, error) {
+	var ss serialStruct
+
+	if nil == s.file {
+		return ss, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	errno := s.ioctl(uintptr(unix.TIOCGSERIAL), uintptr(unsafe.Pointer(&ss)))
+
+	return ss, s.serialErr("TIOCGSERIAL", errno)
+}
+
+func (s *Serial) setSerial(ss serialStruct) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	errno := s.ioctl(uintptr(unix.TIOCSSERIAL), uintptr(unsafe.Pointer(&ss)))
+
+	return s.serialErr("TIOCSSERIAL", errno)
+}
+
+// CloseTiming returns the driver's close_delay, how long DTR is held low
+// after the port is closed, and closing_wait, how long closing the port
+// waits for pending output to be sent.  A closing wait of 0 means close
+// does not wait, ClosingWaitForever means it waits without a limit.
+func (s *Serial) CloseTiming() (closeDelay, closingWait time.Duration, err error) {
+	ss, err := s.getSerial()
+	if nil != err {
+		return 0, 0, err
+	}
+
+	closeDelay = time.Duration(ss.CloseDelay) * 10 * time.Millisecond
+	switch ss.ClosingWait {
+	case closingWaitNone:
+		closingWait = 0
+	case closingWaitInf:
+		closingWait = ClosingWaitForever
+	default:
+		closingWait = time.Duration(ss.ClosingWait) * 10 * time.Millisecond
+	}
+
+	return closeDelay, closingWait, nil
+}
+
+// SetCloseTiming sets the driver's close_delay and closing_wait, which the
+// kernel keeps in hundredths of a second.  A closing wait of 0 makes close
+// return without waiting for pending output, ClosingWaitForever makes it
+// wait without a limit.  Most drivers require CAP_SYS_ADMIN to change these.
+func (s *Serial) SetCloseTiming(closeDelay, closingWait time.Duration) error {
+	ss, err := s.getSerial()
+	if nil != err {
+		return err
+	}
+
+	ss.CloseDelay = uint16(clampCentis(closeDelay, 0, 65535))
+	switch {
+	case 0 == closingWait:
+		ss.ClosingWait = closingWaitNone
+	case closingWait < 0:
+		ss.ClosingWait = closingWaitInf
+	default:
+		ss.ClosingWait = uint16(clampCentis(closingWait, 1, closingWaitNone-1))
+	}
+
+	return s.setSerial(ss)
+}
+
+// MaxBaud returns the highest baud rate the UART supports, which is its
+// baud_base: the rate produced with a divisor of 1.  Drivers that don't
+// report a baud_base, such as many USB adapters, return ErrNotSupported.
+func (s *Serial) MaxBaud() (int, error) {
+	ss, err := s.getSerial()
+	if nil != err {
+		return 0, err
+	}
+
+	if ss.BaudBase <= 0 {
+		return 0, fmt.Errorf("Serial port '%s' does not report its base baud rate: %w", s.Name, ErrNotSupported)
+	}
+
+	return int(ss.BaudBase), nil
+}
+
+// BaudError reports the rate the UART actually runs at for the configured
+// Baud, along with the deviation in percent.  The actual rate is derived
+// from baud_base and the nearest divisor, the same way the kernel picks it
+// for 8250 style UARTs, so a deviation beyond about 2% is likely to cause
+// bit errors.  Drivers that don't report a baud_base return ErrNotSupported.
+func (s *Serial) BaudError() (requested, actual int, pctErr float64, err error) {
+	requested = s.Baud
+	if requested < 1 {
+		return requested, 0, 0, fmt.Errorf("Invalid baud rate parameter.")
+	}
+
+	base, err := s.MaxBaud()
+	if nil != err {
+		return requested, 0, 0, err
+	}
+
+	divisor := (base + requested/2) / requested
+	if divisor < 1 {
+		divisor = 1
+	}
+	actual = base / divisor
+	pctErr = float64(actual-requested) * 100 / float64(requested)
+
+	return requested, actual, pctErr, nil
+}
+
+// GetFIFOSize returns the size of the UART's transmit FIFO (xmit_fifo_size).
+// Drivers that don't report it return ErrNotSupported.
+func (s *Serial) GetFIFOSize() (int, error) {
+	ss, err := s.getSerial()
+	if nil != err {
+		return 0, err
+	}
+
+	if ss.XmitFifoSize <= 0 {
+		return 0, fmt.Errorf("Serial port '%s' does not report its FIFO size: %w", s.Name, ErrNotSupported)
+	}
+
+	return int(ss.XmitFifoSize), nil
+}
+
+// SetFIFOSize sets the transmit FIFO size (xmit_fifo_size) the driver uses.
+// Most drivers require CAP_SYS_ADMIN to change it, and drivers that ignore
+// the new size return ErrNotSupported.
+func (s *Serial) SetFIFOSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("Invalid FIFO size: %d", size)
+	}
+
+	ss, err := s.getSerial()
+	if nil != err {
+		return err
+	}
+
+	ss.XmitFifoSize = int32(size)
+	if err := s.setSerial(ss); nil != err {
+		return err
+	}
+
+	ss, err = s.getSerial()
+	if nil != err {
+		return err
+	}
+	if int(ss.XmitFifoSize) != size {
+		return fmt.Errorf("Serial port '%s' does not allow changing its FIFO size: %w", s.Name, ErrNotSupported)
+	}
+
+	return nil
+}
+
+// clampCentis converts the duration into hundredths of a second, limited to
+// the range lo to hi.
+func clampCentis(d time.Duration, lo, hi int64) int64 {
+	c := int64(d / (10 * time.Millisecond))
+	if c < lo {
+		return lo
+	}
+	if hi < c {
+		return hi
+	}
+
+	return c
+}
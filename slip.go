@@ -0,0 +1,131 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"io"
+)
+
+// The special bytes used by SLIP (RFC 1055).
+const (
+	slipEnd    = 0xc0
+	slipEsc    = 0xdb
+	slipEscEnd = 0xdc
+	slipEscEsc = 0xdd
+)
+
+// SLIP is a Codec implementing the Serial Line Internet Protocol framing
+// described in RFC 1055.
+type SLIP struct{}
+
+// Encode escapes the payload and wraps it in END bytes.  The leading END
+// flushes any line noise received by the peer before the frame.
+func (SLIP) Encode(payload []byte) ([]byte, error) {
+	frame := make([]byte, 0, len(payload)+2)
+	frame = append(frame, slipEnd)
+	for _, b := range payload {
+		switch b {
+		case slipEnd:
+			frame = append(frame, slipEsc, slipEscEnd)
+		case slipEsc:
+			frame = append(frame, slipEsc, slipEscEsc)
+		default:
+			frame = append(frame, b)
+		}
+	}
+
+	return append(frame, slipEnd), nil
+}
+
+// Decode removes the escaping from a received frame.
+func (SLIP) Decode(frame []byte) ([]byte, error) {
+	payload := make([]byte, 0, len(frame))
+	for i := 0; i < len(frame); i++ {
+		if slipEsc != frame[i] {
+			payload = append(payload, frame[i])
+			continue
+		}
+
+		i++
+		if len(frame) <= i {
+			return nil, fmt.Errorf("SLIP frame ends with an escape byte.")
+		}
+
+		switch frame[i] {
+		case slipEscEnd:
+			payload = append(payload, slipEnd)
+		case slipEscEsc:
+			payload = append(payload, slipEsc)
+		default:
+			return nil, fmt.Errorf("Invalid SLIP escape sequence 0x%02x 0x%02x.", slipEsc, frame[i])
+		}
+	}
+
+	return payload, nil
+}
+
+// Delimiter returns the SLIP END byte.
+func (SLIP) Delimiter() byte {
+	return slipEnd
+}
+
+// SlipReader reads whole SLIP frames from a port.  Each Read returns the
+// payload of exactly one frame.
+type SlipReader struct {
+	Port io.Reader // The port to read frames from, usually a *Serial
+
+	frames frameReader
+}
+
+// Read reads the next SLIP frame and copies its payload into b.  Partial
+// frames are buffered between calls.  If the port returns without any
+// data, Read returns as well.  If b is too small for the payload
+// io.ErrShortBuffer is returned and the frame is discarded.
+func (r *SlipReader) Read(b []byte) (n int, err error) {
+	frame, err := r.frames.next(r.Port, slipEnd)
+	if nil != err || nil == frame {
+		return 0, err
+	}
+
+	payload, err := SLIP{}.Decode(frame)
+	if nil != err {
+		return 0, err
+	}
+	if len(b) < len(payload) {
+		return 0, io.ErrShortBuffer
+	}
+
+	return copy(b, payload), nil
+}
+
+// SlipWriter writes whole SLIP frames to a port.  Each Write sends exactly
+// one frame.
+type SlipWriter struct {
+	Port io.Writer // The port to write frames to, usually a *Serial
+}
+
+// Write encodes b as a single SLIP frame and writes it to the port.
+func (w *SlipWriter) Write(b []byte) (n int, err error) {
+	frame, _ := SLIP{}.Encode(b)
+	if err := writeAll(w.Port, frame); nil != err {
+		return 0, err
+	}
+
+	return len(b), nil
+}
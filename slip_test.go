@@ -0,0 +1,144 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSLIPEncode(t *testing.T) {
+	tests := []struct {
+		description string
+		payload     []byte
+		expected    []byte
+	}{
+		{
+			description: "empty",
+			payload:     []byte{},
+			expected:    []byte{0xc0, 0xc0},
+		}, {
+			description: "plain",
+			payload:     []byte{0x01, 0x02, 0x03},
+			expected:    []byte{0xc0, 0x01, 0x02, 0x03, 0xc0},
+		}, {
+			description: "END in the payload",
+			payload:     []byte{0x01, 0xc0, 0x02},
+			expected:    []byte{0xc0, 0x01, 0xdb, 0xdc, 0x02, 0xc0},
+		}, {
+			description: "ESC in the payload",
+			payload:     []byte{0x01, 0xdb, 0x02},
+			expected:    []byte{0xc0, 0x01, 0xdb, 0xdd, 0x02, 0xc0},
+		}, {
+			description: "escaped bytes are left alone",
+			payload:     []byte{0xdc, 0xdd},
+			expected:    []byte{0xc0, 0xdc, 0xdd, 0xc0},
+		}, {
+			description: "only special bytes",
+			payload:     []byte{0xc0, 0xdb, 0xdb, 0xc0},
+			expected:    []byte{0xc0, 0xdb, 0xdc, 0xdb, 0xdd, 0xdb, 0xdd, 0xdb, 0xdc, 0xc0},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			frame, err := SLIP{}.Encode(tc.payload)
+			if nil != err {
+				t.Fatalf("Encode() error: %v", err)
+			}
+			if !bytes.Equal(tc.expected, frame) {
+				t.Fatalf("Encode() = % x, expected % x", frame, tc.expected)
+			}
+
+			// The codec decodes frames without their END delimiters.
+			payload, err := SLIP{}.Decode(frame[1 : len(frame)-1])
+			if nil != err {
+				t.Fatalf("Decode() error: %v", err)
+			}
+			if !bytes.Equal(tc.payload, payload) {
+				t.Fatalf("Decode() = % x, expected % x", payload, tc.payload)
+			}
+		})
+	}
+}
+
+func TestSLIPDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		description string
+		frame       []byte
+	}{
+		{
+			description: "trailing ESC",
+			frame:       []byte{0x01, 0xdb},
+		}, {
+			description: "unknown escape",
+			frame:       []byte{0xdb, 0x01},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if _, err := (SLIP{}).Decode(tc.frame); nil == err {
+				t.Fatalf("Decode(% x) succeeded, expected an error", tc.frame)
+			}
+		})
+	}
+}
+
+func TestSlipReaderWriter(t *testing.T) {
+	payloads := [][]byte{
+		{0x01, 0x02},
+		{0xc0},
+		{0xdb, 0xdc, 0xdd, 0xc0},
+		{0x45, 0x00, 0xc0, 0xdb, 0xff},
+	}
+
+	var buf bytes.Buffer
+	w := &SlipWriter{Port: &buf}
+	for _, p := range payloads {
+		n, err := w.Write(p)
+		if nil != err || len(p) != n {
+			t.Fatalf("Write(% x) = %d, %v", p, n, err)
+		}
+	}
+
+	r := &SlipReader{Port: &buf}
+	b := make([]byte, 16)
+	for _, p := range payloads {
+		n, err := r.Read(b)
+		if nil != err {
+			t.Fatalf("Read() error: %v", err)
+		}
+		if !bytes.Equal(p, b[:n]) {
+			t.Fatalf("Read() = % x, expected % x", b[:n], p)
+		}
+	}
+
+	if _, err := r.Read(b); io.EOF != err {
+		t.Fatalf("Read() at the end = %v, expected io.EOF", err)
+	}
+}
+
+func TestSlipReaderShortBuffer(t *testing.T) {
+	r := &SlipReader{Port: bytes.NewReader([]byte{0xc0, 0x01, 0x02, 0x03, 0xc0})}
+
+	if _, err := r.Read(make([]byte, 2)); io.ErrShortBuffer != err {
+		t.Fatalf("Read() = %v, expected io.ErrShortBuffer", err)
+	}
+}
@@ -0,0 +1,72 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import "time"
+
+// Stats holds the traffic counters of a port since they were last reset.
+type Stats struct {
+	BytesRead    uint64    // Bytes read from the port
+	BytesWritten uint64    // Bytes written to the port
+	Since        time.Time // When the counters were last reset
+
+	// ReadRate and WriteRate are the average throughput in bytes per
+	// second since the counters were last reset.
+	ReadRate  float64
+	WriteRate float64
+}
+
+// Stats returns the number of bytes read from and written to the port.
+// The counters start when the port is first opened and are kept across
+// Close, Open and reconnects until ResetStats is called.  Input read ahead
+// by the timed read helpers is counted when it is read from the port.
+func (s *Serial) Stats() Stats {
+	s.mu.Lock()
+	since := s.statsSince
+	s.mu.Unlock()
+
+	st := Stats{
+		BytesRead:    s.bytesRead.Load(),
+		BytesWritten: s.bytesWritten.Load(),
+		Since:        since,
+	}
+	if elapsed := time.Since(since).Seconds(); !since.IsZero() && 0 < elapsed {
+		st.ReadRate = float64(st.BytesRead) / elapsed
+		st.WriteRate = float64(st.BytesWritten) / elapsed
+	}
+
+	return st
+}
+
+// ResetStats sets the traffic counters back to zero.
+func (s *Serial) ResetStats() {
+	s.mu.Lock()
+	s.statsSince = time.Now()
+	s.bytesRead.Store(0)
+	s.bytesWritten.Store(0)
+	s.mu.Unlock()
+}
+
+// startStats starts the traffic counters if they haven't been started.
+func (s *Serial) startStats() {
+	s.mu.Lock()
+	if s.statsSince.IsZero() {
+		s.statsSince = time.Now()
+	}
+	s.mu.Unlock()
+}
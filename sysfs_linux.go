@@ -0,0 +1,313 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sysClassTTY is the sysfs directory holding an entry for every tty.
+var sysClassTTY = "/sys/class/tty"
+
+// sysfsTTY returns the sysfs directory for the tty behind the named device,
+// resolving symlinks such as those in /dev/serial/by-id.
+func sysfsTTY(name string) (string, error) {
+	real, err := filepath.EvalSymlinks(name)
+	if nil != err {
+		return "", err
+	}
+
+	dir := filepath.Join(sysClassTTY, filepath.Base(real))
+	if _, err := os.Stat(dir); nil != err {
+		return "", fmt.Errorf("No sysfs entry for '%s': %w", name, err)
+	}
+
+	return dir, nil
+}
+
+// TTYName returns the kernel name of the open port's tty, such as "ttyUSB0"
+// or "pts/3", matching the names in the kernel log.  It is resolved from
+// the open file descriptor, so a symlink such as one in /dev/serial/by-id
+// gives the real tty.
+func (s *Serial) TTYName() (string, error) {
+	if nil == s.file {
+		return "", fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	real, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", s.fd))
+	if nil != err {
+		return "", fmt.Errorf("Unable to resolve the tty of '%s': %w", s.Name, err)
+	}
+
+	return strings.TrimPrefix(real, "/dev/"), nil
+}
+
+// SetFlowThresholds tunes when the UART holds off the peer as its receive
+// FIFO fills.  The only interface the kernel offers is the rx_trig_bytes
+// attribute of 8250/16550A family UARTs, the receive FIFO trigger level
+// that parts with automatic RTS flow control (16750, 16950 and similar)
+// also use to deassert RTS.  The driver rounds high to a level the hardware
+// supports.  No driver exposes a separate low threshold, so a non-zero low
+// returns ErrNotSupported, as does a port without rx_trig_bytes (USB
+// adapters, ptys).  Changing the attribute usually requires root.
+func (s *Serial) SetFlowThresholds(high, low int) error {
+	if 0 != low {
+		return fmt.Errorf("Serial port '%s' can't set a low flow threshold: %w", s.Name, ErrNotSupported)
+	}
+	if high < 1 {
+		return fmt.Errorf("Invalid high flow threshold: %d", high)
+	}
+
+	dir, err := sysfsTTY(s.Name)
+	if nil != err {
+		return err
+	}
+
+	attr := filepath.Join(dir, "rx_trig_bytes")
+	if _, err := os.Stat(attr); nil != err {
+		return fmt.Errorf("Serial port '%s' has no rx_trig_bytes: %w", s.Name, ErrNotSupported)
+	}
+
+	return os.WriteFile(attr, []byte(strconv.Itoa(high)), 0)
+}
+
+// DriverName returns the name of the kernel driver behind the named serial
+// port, such as "ftdi_sio", "ch341" or "cp210x", so driver specific quirks
+// can be applied.  It is read from the device's driver link in sysfs, which
+// virtual terminals and ptys don't have.
+func DriverName(name string) (string, error) {
+	dir, err := sysfsTTY(name)
+	if nil != err {
+		return "", err
+	}
+
+	driver, err := filepath.EvalSymlinks(filepath.Join(dir, "device", "driver"))
+	if nil != err {
+		return "", fmt.Errorf("'%s' has no driver: %w", name, err)
+	}
+
+	return filepath.Base(driver), nil
+}
+
+// SerialNumber returns the serial number in the USB descriptor of the
+// adapter behind the named serial port.  Together with the vendor and
+// product it identifies an adapter across reboots and ports.  Ports
+// without a serial descriptor, including non-USB ports, return
+// ErrNotSupported.
+func SerialNumber(name string) (string, error) {
+	return usbAttr(name, "serial")
+}
+
+// usbAttr reads an attribute of the USB device behind the named serial
+// port.  It is looked up in the parents of the tty's device in sysfs, since
+// it belongs to the USB device rather than its interface.  Ports without
+// the attribute, including non-USB ports, return ErrNotSupported.
+func usbAttr(name, attr string) (string, error) {
+	dir, err := sysfsTTY(name)
+	if nil != err {
+		return "", err
+	}
+
+	dev, err := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+	if nil != err {
+		return "", fmt.Errorf("'%s' has no device: %w", name, ErrNotSupported)
+	}
+
+	for ; "/sys/devices" != dev && "/" != dev; dev = filepath.Dir(dev) {
+		b, err := os.ReadFile(filepath.Join(dev, attr))
+		if nil == err {
+			return strings.TrimSpace(string(b)), nil
+		}
+	}
+
+	return "", fmt.Errorf("'%s' has no %s: %w", name, attr, ErrNotSupported)
+}
+
+// PortLocation returns where the USB adapter behind the named serial port
+// is plugged in, as the bus and port path of its interface such as
+// "1-1.2:1.0".  It stays the same as long as the adapter is plugged into
+// the same USB port, so it tells identical adapters without serial numbers
+// apart.  Non-USB ports return ErrNotSupported.
+func PortLocation(name string) (string, error) {
+	dir, err := sysfsTTY(name)
+	if nil != err {
+		return "", err
+	}
+
+	dev, err := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+	if nil != err {
+		return "", fmt.Errorf("'%s' has no device: %w", name, ErrNotSupported)
+	}
+
+	// The interface is the first parent with an interface number.
+	for ; "/sys/devices" != dev && "/" != dev; dev = filepath.Dir(dev) {
+		if _, err := os.Stat(filepath.Join(dev, "bInterfaceNumber")); nil == err {
+			return filepath.Base(dev), nil
+		}
+	}
+
+	return "", fmt.Errorf("'%s' is not a USB serial port: %w", name, ErrNotSupported)
+}
+
+// PortInfo describes a serial port found by ListPorts.  The USB fields are
+// empty for ports that aren't USB adapters.
+type PortInfo struct {
+	Name         string // The path of the port in /dev/serial/by-id
+	Driver       string // The kernel driver, see DriverName
+	VendorID     string // The USB vendor id, such as "0403"
+	ProductID    string // The USB product id, such as "6001"
+	SerialNumber string // The USB serial number, see SerialNumber
+	Location     string // Where the adapter is plugged in, see PortLocation
+}
+
+// ListPorts lists the available serial ports, like FindSerialPorts, along
+// with what sysfs reports about each of them.
+func ListPorts() ([]PortInfo, error) {
+	names, err := FindSerialPorts()
+	if nil != err {
+		return nil, err
+	}
+
+	list := make([]PortInfo, 0, len(names))
+	for _, name := range names {
+		p := PortInfo{Name: name}
+		p.Driver, _ = DriverName(name)
+		p.VendorID, _ = usbAttr(name, "idVendor")
+		p.ProductID, _ = usbAttr(name, "idProduct")
+		p.SerialNumber, _ = SerialNumber(name)
+		p.Location, _ = PortLocation(name)
+		list = append(list, p)
+	}
+
+	return list, nil
+}
+
+// PortFilter selects ports by their PortInfo.  Empty fields match any
+// port.
+type PortFilter struct {
+	Driver       string
+	VendorID     string
+	ProductID    string
+	SerialNumber string
+}
+
+// Match reports if the port matches the filter.  The ids are compared
+// without regard to case.
+func (f PortFilter) Match(p PortInfo) bool {
+	return ("" == f.Driver || f.Driver == p.Driver) &&
+		("" == f.VendorID || strings.EqualFold(f.VendorID, p.VendorID)) &&
+		("" == f.ProductID || strings.EqualFold(f.ProductID, p.ProductID)) &&
+		("" == f.SerialNumber || f.SerialNumber == p.SerialNumber)
+}
+
+// OpenAllMatching opens every port ListPorts finds that matches the filter
+// with the baud rate and configuration.  If one fails to open, the ports
+// already opened are closed and the error is returned, unless bestEffort
+// is set: then the ports that opened are returned along with an error
+// listing the ones that didn't.
+func OpenAllMatching(filter PortFilter, baud int, config string, bestEffort bool) ([]*Serial, error) {
+	ports, err := ListPorts()
+	if nil != err {
+		return nil, err
+	}
+
+	var opened []*Serial
+	var errs []error
+	for _, p := range ports {
+		if !filter.Match(p) {
+			continue
+		}
+
+		s := &Serial{Name: p.Name, Baud: baud, Config: config}
+		if err := s.Open(); nil != err {
+			s.Close()
+			err = fmt.Errorf("'%s': %w", p.Name, err)
+			if !bestEffort {
+				for _, o := range opened {
+					o.Close()
+				}
+				return nil, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		opened = append(opened, s)
+	}
+
+	if 0 < len(errs) {
+		return opened, fmt.Errorf("Not every matching serial port could be opened: %w", errors.Join(errs...))
+	}
+
+	return opened, nil
+}
+
+// fifoTimeoutChars is how many character times a 16550 style UART waits
+// before reporting received bytes that haven't reached the FIFO trigger
+// level.
+const fifoTimeoutChars = 4
+
+// EffectiveLatency returns how long it can take the hardware behind the
+// port to deliver a received byte, so read timeouts aren't set shorter
+// than the port can deliver.  It adds up the time to receive one character
+// at the configured rate, the latency timer of USB adapters that buffer
+// the input, such as the latency_timer of FTDI adapters, and, for UARTs
+// with a receive FIFO (rx_trig_bytes), the character timeout after which
+// the UART reports a partly filled FIFO.  The adapter settings are read
+// from sysfs each call since they can be changed at any time.
+func (s *Serial) EffectiveLatency() (time.Duration, error) {
+	char, buffering, err := s.latency()
+	if nil != err {
+		return 0, err
+	}
+
+	return char + buffering, nil
+}
+
+// latency returns the time to receive one character and the added latency
+// of the buffering in the hardware.
+func (s *Serial) latency() (char, buffering time.Duration, err error) {
+	char, err = s.ByteDuration()
+	if nil != err {
+		return 0, 0, err
+	}
+
+	dir, err := sysfsTTY(s.Name)
+	if nil != err {
+		return 0, 0, err
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, "device", "latency_timer")); nil == err {
+		ms, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if nil != err {
+			return 0, 0, fmt.Errorf("Invalid latency_timer for '%s': %w", s.Name, err)
+		}
+		buffering += time.Duration(ms) * time.Millisecond
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "rx_trig_bytes")); nil == err {
+		buffering += fifoTimeoutChars * char
+	}
+
+	return char, buffering, nil
+}
@@ -0,0 +1,57 @@
+//go:build !ppc64 && !ppc64le
+// +build !ppc64,!ppc64le
+
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTermios2 applies the settings using TCSETS2 so the explicit input and
+// output speeds are honored.  On these architectures unix.Termios has the
+// same layout as the kernel's struct termios2.
+func (s *Serial) setTermios2(t unix.Termios) error {
+	errno := s.ioctl(uintptr(unix.TCSETS2), uintptr(unsafe.Pointer(&t)))
+	if 0 != errno {
+		return fmt.Errorf("ioctl( '%s', TCSETS2, &t ) error: %d", s.Name, errno)
+	}
+
+	return nil
+}
+
+// getTermios2 reads the settings using TCGETS2, which includes the
+// explicit input and output speeds.
+func (s *Serial) getTermios2() (unix.Termios, error) {
+	var t unix.Termios
+
+	if nil == s.file {
+		return t, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	errno := s.ioctl(uintptr(unix.TCGETS2), uintptr(unsafe.Pointer(&t)))
+	if 0 != errno {
+		return t, fmt.Errorf("ioctl( '%s', TCGETS2, &t ) error: %d", s.Name, errno)
+	}
+
+	return t, nil
+}
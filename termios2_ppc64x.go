@@ -0,0 +1,36 @@
+//go:build linux && (ppc64 || ppc64le)
+// +build linux
+// +build ppc64 ppc64le
+
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import "golang.org/x/sys/unix"
+
+// setTermios2 applies the settings.  On powerpc the regular termios already
+// carries the explicit input and output speeds, so TCSETS is used.
+func (s *Serial) setTermios2(t unix.Termios) error {
+	return s.SetTermios(t)
+}
+
+// getTermios2 reads the settings, which on powerpc already include the
+// explicit input and output speeds.
+func (s *Serial) getTermios2() (unix.Termios, error) {
+	return s.GetTermios()
+}
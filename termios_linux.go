@@ -0,0 +1,127 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// MakeRaw sets t to raw mode like cfmakeraw(3): input is available a byte
+// at a time, echo and the signal characters are disabled, no input or
+// output translation is done and characters are 8 bits without parity.
+func MakeRaw(t *unix.Termios) {
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+}
+
+// baudFromConstant is the reverse of baudMap.
+var baudFromConstant = func() map[uint32]int {
+	m := make(map[uint32]int, len(baudMap))
+	for baud, rate := range baudMap {
+		m[rate] = baud
+	}
+
+	return m
+}()
+
+// BaudConstant returns the termios Bnnn speed constant for the baud rate,
+// and false if the rate has no constant and must be set as a custom rate.
+func BaudConstant(baud int) (uint32, bool) {
+	rate, ok := baudMap[baud]
+
+	return rate, ok
+}
+
+// BaudFromConstant returns the baud rate of a termios Bnnn speed constant,
+// such as the CBAUD bits of a Cflag, and false if it isn't one.
+func BaudFromConstant(rate uint32) (int, bool) {
+	baud, ok := baudFromConstant[rate]
+
+	return baud, ok
+}
+
+// baudRate returns the Bnnn constant for the baud rate, or BOTHER for rates
+// without one, which are set via the termios2 interface.
+func baudRate(baud int) (uint32, error) {
+	if rate, ok := baudMap[baud]; ok {
+		return rate, nil
+	}
+	if 0 < baud {
+		return unix.BOTHER, nil
+	}
+
+	return 0, fmt.Errorf("Invalid baud rate parameter.")
+}
+
+// cfsetospeed sets the output baud rate in t like cfsetospeed(3).
+func cfsetospeed(t *unix.Termios, baud int) error {
+	rate, err := baudRate(baud)
+	if nil != err {
+		return err
+	}
+
+	t.Cflag = t.Cflag&^unix.CBAUD | rate
+	t.Ospeed = uint32(baud)
+
+	return nil
+}
+
+// cfsetispeed sets the input baud rate in t like cfsetispeed(3).  The input
+// rate is kept in the CIBAUD bits, which the kernel treats as matching the
+// output rate when left at zero.
+func cfsetispeed(t *unix.Termios, baud int) error {
+	rate, err := baudRate(baud)
+	if nil != err {
+		return err
+	}
+
+	t.Cflag = t.Cflag&^unix.CIBAUD | rate<<unix.IBSHIFT
+	t.Ispeed = uint32(baud)
+
+	return nil
+}
+
+// BuildTermios returns the termios settings UpdateCfg applies for the baud
+// rate and configuration string with every other Serial field at its
+// default, without needing a port, for example to compare against golden
+// values in tests.
+func BuildTermios(baud int, cfg string) (unix.Termios, error) {
+	s := &Serial{Baud: baud, Config: cfg}
+
+	return s.buildTermios()
+}
+
+// EffectiveBaud returns the requested Baud along with the input and output
+// speeds the driver actually set, which may be rounded to the nearest rate
+// it can produce, especially for custom rates.
+func (s *Serial) EffectiveBaud() (requested, input, output int, err error) {
+	t, err := s.getTermios2()
+	if nil != err {
+		return s.Baud, 0, 0, err
+	}
+
+	return s.Baud, int(t.Ispeed), int(t.Ospeed), nil
+}
@@ -0,0 +1,113 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestBaudConstant(t *testing.T) {
+	tests := []struct {
+		baud     int
+		constant uint32
+	}{
+		{baud: 50, constant: unix.B50},
+		{baud: 9600, constant: unix.B9600},
+		{baud: 38400, constant: unix.B38400},
+		{baud: 57600, constant: unix.B57600},
+		{baud: 115200, constant: unix.B115200},
+		{baud: 4000000, constant: unix.B4000000},
+	}
+
+	for _, tc := range tests {
+		constant, ok := BaudConstant(tc.baud)
+		if !ok || tc.constant != constant {
+			t.Errorf("BaudConstant(%d) = 0x%x, %v, expected 0x%x", tc.baud, constant, ok, tc.constant)
+		}
+
+		baud, ok := BaudFromConstant(tc.constant)
+		if !ok || tc.baud != baud {
+			t.Errorf("BaudFromConstant(0x%x) = %d, %v, expected %d", tc.constant, baud, ok, tc.baud)
+		}
+	}
+}
+
+func TestBaudConstantRoundTrip(t *testing.T) {
+	for baud := range baudMap {
+		constant, ok := BaudConstant(baud)
+		if !ok {
+			t.Fatalf("BaudConstant(%d) has no constant", baud)
+		}
+
+		back, ok := BaudFromConstant(constant)
+		if !ok || baud != back {
+			t.Fatalf("BaudFromConstant(BaudConstant(%d)) = %d, %v", baud, back, ok)
+		}
+	}
+
+	if len(baudMap) != len(baudFromConstant) {
+		t.Fatalf("%d rates map to %d constants", len(baudMap), len(baudFromConstant))
+	}
+}
+
+func TestBaudConstantUnknown(t *testing.T) {
+	if _, ok := BaudConstant(250000); ok {
+		t.Errorf("BaudConstant(250000) has a constant, expected a custom rate")
+	}
+	if _, ok := BaudFromConstant(unix.BOTHER); ok {
+		t.Errorf("BaudFromConstant(BOTHER) succeeded")
+	}
+	// B0 means hang up, not a rate.
+	if _, ok := BaudFromConstant(unix.B0); ok {
+		t.Errorf("BaudFromConstant(B0) succeeded")
+	}
+}
+
+func TestBuildTermios(t *testing.T) {
+	term, err := BuildTermios(9600, "7E2")
+	if nil != err {
+		t.Fatalf("BuildTermios() error: %v", err)
+	}
+
+	want := uint32(unix.B9600 | unix.CS7 | unix.PARENB | unix.CSTOPB | unix.CREAD)
+	if want != term.Cflag&want || 0 != term.Cflag&unix.PARODD {
+		t.Errorf("Cflag = 0x%x, expected 0x%x set without PARODD", term.Cflag, want)
+	}
+	if 0 != term.Lflag&(unix.ICANON|unix.ECHO) || 0 != term.Oflag&unix.OPOST {
+		t.Errorf("Lflag 0x%x, Oflag 0x%x, expected raw", term.Lflag, term.Oflag)
+	}
+
+	// It matches what UpdateCfg applies to a port.
+	port := Serial{Config: "7E2"}
+	openPair(t, &port)
+	applied, err := port.GetTermios()
+	if nil != err {
+		t.Fatalf("GetTermios() error: %v", err)
+	}
+	if term.Iflag != applied.Iflag || term.Lflag != applied.Lflag || term.Cc != applied.Cc {
+		t.Errorf("BuildTermios() = %+v, port has %+v", term, applied)
+	}
+
+	for _, cfg := range []string{"9N1", "8X1", "8N3", "8N"} {
+		if _, err := BuildTermios(9600, cfg); nil == err {
+			t.Errorf("BuildTermios(9600, %q) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,1216 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// poll waits until one of the events is ready on the serial port or the
+// deadline passes.  A zero deadline waits forever.  It reports whether the
+// port became ready.
+func (s *Serial) poll(events int16, deadline time.Time) (bool, error) {
+	return s.pollUntil(events, func() time.Time { return deadline })
+}
+
+// pollUntil is poll with a deadline that is evaluated again whenever one of
+// the deadlines of the port changes.  Closing the port ends the wait with
+// an error wrapping os.ErrClosed.
+func (s *Serial) pollUntil(events int16, deadline func() time.Time) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(s.fd), Events: events}}
+
+	if d := deadline(); d.IsZero() || time.Now().Before(d) {
+		wake, err := s.addWaiter()
+		if nil != err {
+			return false, err
+		}
+		defer s.removeWaiter(wake)
+		fds = append(fds, unix.PollFd{Fd: int32(wake), Events: unix.POLLIN})
+	}
+
+	for {
+		ms := -1
+		if d := deadline(); !d.IsZero() {
+			remaining := time.Until(d)
+			if remaining < 0 {
+				remaining = 0
+			}
+			ms = int((remaining + time.Millisecond - 1) / time.Millisecond)
+		}
+
+		n, err := unix.Poll(fds, ms)
+		if unix.EINTR == err {
+			continue
+		}
+		if nil != err {
+			return false, err
+		}
+
+		if 1 < len(fds) && 0 != fds[1].Revents {
+			var b [8]byte
+			unix.Read(int(fds[1].Fd), b[:])
+			fds[1].Revents = 0
+			if err := s.closedErr(); nil != err {
+				return false, err
+			}
+			continue
+		}
+
+		return 0 < n, nil
+	}
+}
+
+// addWaiter registers an eventfd that wake signals, so a poll in progress
+// can be woken.  It fails if the port has been closed.
+func (s *Serial) addWaiter() (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_EVENTFD2, 0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK, 0)
+	if 0 != errno {
+		return -1, fmt.Errorf("Unable to create an eventfd for '%s': %w", s.Name, errno)
+	}
+	fd := int(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nil == s.file || s.closing {
+		unix.Close(fd)
+		return -1, fmt.Errorf("Serial port '%s' closed: %w", s.Name, os.ErrClosed)
+	}
+	if nil == s.waiters {
+		s.waiters = make(map[int]struct{})
+	}
+	s.waiters[fd] = struct{}{}
+
+	return fd, nil
+}
+
+func (s *Serial) removeWaiter(fd int) {
+	s.mu.Lock()
+	delete(s.waiters, fd)
+	s.mu.Unlock()
+
+	unix.Close(fd)
+}
+
+// wake wakes the polls in progress and the reads waiting for the pump.  The
+// caller must hold s.mu.
+func (s *Serial) wake() {
+	one := [8]byte{1}
+	for fd := range s.waiters {
+		unix.Write(fd, one[:])
+	}
+
+	if nil != s.dchange {
+		close(s.dchange)
+		s.dchange = nil
+	}
+}
+
+// deadlineChanged returns a channel closed by the next call to wake.
+func (s *Serial) deadlineChanged() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nil == s.dchange {
+		s.dchange = make(chan struct{})
+	}
+
+	return s.dchange
+}
+
+// closedErr returns an error wrapping os.ErrClosed if the port has been
+// closed or is being closed.
+func (s *Serial) closedErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nil == s.file || s.closing {
+		return fmt.Errorf("Serial port '%s' closed: %w", s.Name, os.ErrClosed)
+	}
+
+	return nil
+}
+
+// getReadDeadline returns the deadline set by SetReadDeadline.
+func (s *Serial) getReadDeadline() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.readDeadline
+}
+
+// getWriteDeadline returns the deadline set by SetWriteDeadline.
+func (s *Serial) getWriteDeadline() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeDeadline
+}
+
+// readAvailable reads only the bytes that are already waiting in the input
+// queue, so the read returns immediately regardless of the Vmin setting.
+func (s *Serial) readAvailable(b []byte) (int, error) {
+	var waiting int32
+
+	errno := s.ioctl(uintptr(unix.TIOCINQ), uintptr(unsafe.Pointer(&waiting)))
+	if 0 == errno && 0 < waiting && int(waiting) < len(b) {
+		b = b[:waiting]
+	}
+
+	n, err := s.file.Read(b)
+	s.received(b[:n])
+
+	// A read that returns without data is reported as io.EOF.
+	if 0 == n && io.EOF == err {
+		err = nil
+	}
+
+	return n, err
+}
+
+// deadlineFor converts a timeout into a deadline.  A zero timeout falls
+// back to the default Timeout, if there is one, and otherwise gives a zero
+// deadline, which waits forever.
+func (s *Serial) deadlineFor(timeout time.Duration) time.Time {
+	if 0 == timeout {
+		timeout = s.Timeout
+	}
+	if 0 < timeout {
+		return time.Now().Add(timeout)
+	}
+
+	return time.Time{}
+}
+
+// earliest returns the earlier of two deadlines, where zero means no
+// deadline.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+
+	return a
+}
+
+// buffered returns the input that has been read ahead, first dropping the
+// LF of a CRLF pair whose CR ended the previous line.  The caller must hold
+// s.mu.
+func (s *Serial) buffered() []byte {
+	if s.skipLF && 0 < len(s.rbuf) {
+		s.skipLF = false
+		if '\n' == s.rbuf[0] {
+			s.rbuf = s.rbuf[1:]
+		}
+	}
+
+	return s.rbuf
+}
+
+// fill waits until the deadline for more input and appends it to the read
+// ahead buffer, or for the pump to do so if it is running.  If nothing
+// arrives os.ErrDeadlineExceeded is returned.
+func (s *Serial) fill(deadline time.Time) error {
+	if p := s.currentPump(); nil != p {
+		return p.wait(s, deadline, nil)
+	}
+
+	ready, err := s.poll(unix.POLLIN, deadline)
+	if nil != err {
+		return err
+	}
+	if !ready {
+		return os.ErrDeadlineExceeded
+	}
+
+	buf := make([]byte, 256)
+	n, err := s.readAvailable(buf)
+
+	s.mu.Lock()
+	s.rbuf = append(s.rbuf, buf[:n]...)
+	s.mu.Unlock()
+
+	return err
+}
+
+// SetTimeoutMode selects how read timeouts are implemented and re-applies
+// the current read timeout using it.
+func (s *Serial) SetTimeoutMode(mode TimeoutMode) error {
+	if TimeoutPoll != mode && TimeoutVTIME != mode {
+		return fmt.Errorf("Invalid timeout mode: %d", mode)
+	}
+
+	s.timeoutMode = mode
+
+	return s.SetReadTimeout(s.readTimeout)
+}
+
+// SetReadTimeout sets how long Read waits for data before returning 0 bytes
+// and a nil error.  By default the timeout is handled by polling the port,
+// giving millisecond precision; with TimeoutVTIME it is rounded up to a
+// multiple of 100ms and handled by the kernel via Vtime.  A zero timeout
+// restores waiting for the configured Vtime.  A timeout shorter than the
+// EffectiveLatency of the port is still applied; use CheckReadTimeout to
+// find out, and if a Logger is set it is also logged as a warning.
+func (s *Serial) SetReadTimeout(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("Invalid read timeout: %s", d)
+	}
+	if TimeoutVTIME == s.timeoutMode && 255*100*time.Millisecond < d {
+		return fmt.Errorf("Read timeout %s is too long for VTIME.", d)
+	}
+	if 0 < d && nil != s.file && nil != s.Logger {
+		if err := s.CheckReadTimeout(d); errors.Is(err, ErrTimeoutBelowLatency) {
+			s.Logger.Warn("serial port read timeout is shorter than its latency",
+				"port", s.Name,
+				"timeout", d,
+				"error", err)
+		}
+	}
+
+	s.readTimeout = d
+	s.pollRead = 0 < d && TimeoutPoll == s.timeoutMode
+	if s.pollRead {
+		return nil
+	}
+
+	if 0 < d {
+		s.Vtime = (d + 100*time.Millisecond - 1) / (100 * time.Millisecond) * (100 * time.Millisecond)
+		s.blockForVmin = false
+	}
+	if nil == s.file {
+		return nil
+	}
+
+	return s.UpdateCfg()
+}
+
+// CheckReadTimeout reports whether a read timeout is long enough for the
+// port to deliver the data, returning an error wrapping
+// ErrTimeoutBelowLatency if it is shorter than the EffectiveLatency of a
+// port that buffers the input, such as a USB adapter with a latency timer.
+// Other ports aren't checked, since there a short timeout just checks for
+// input that is already waiting.  An error reading the latency is returned
+// as is.
+func (s *Serial) CheckReadTimeout(d time.Duration) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	char, buffering, err := s.latency()
+	if nil != err {
+		return err
+	}
+	if 0 == buffering || char+buffering <= d {
+		return nil
+	}
+
+	return fmt.Errorf("Serial port '%s' read timeout %s is shorter than its latency of %s: %w", s.Name, d, char+buffering, ErrTimeoutBelowLatency)
+}
+
+// Serial can be used wherever a net.Conn is expected.
+var _ net.Conn = (*Serial)(nil)
+
+// SetReadDeadline sets the time after which Read returns
+// os.ErrDeadlineExceeded instead of waiting for data.  Until then Read waits
+// for at least one byte, ignoring Vtime and Polling.  A zero time clears the
+// deadline.  It can be called while a Read is waiting, which then uses the
+// new deadline.
+func (s *Serial) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	s.wake()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// SetWriteDeadline sets the time after which Write gives up waiting for
+// room in the output buffer, for example while hardware flow control holds
+// off the output, and returns os.ErrDeadlineExceeded along with the number
+// of bytes written.  A zero time clears the deadline.  It can be called
+// while a Write is waiting, which then uses the new deadline.
+func (s *Serial) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.writeDeadline = t
+	s.wake()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// SetDeadline sets both the read and the write deadline.
+func (s *Serial) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	s.writeDeadline = t
+	s.wake()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// writeChunk is how much Write sends each time the port reports room in
+// its output buffer.  The kernel only reports room once fewer than 256
+// bytes are waiting, so chunks of this size normally don't block.
+const writeChunk = 256
+
+// currentWriteDeadline returns the deadline set by SetWriteDeadline or, if
+// there isn't one, the default Timeout from now.
+func (s *Serial) currentWriteDeadline() time.Time {
+	return s.writeDeadlineFunc()()
+}
+
+// writeDeadlineFunc returns the deadline of a Write starting now, for a
+// wait that picks up changes made by SetWriteDeadline while it waits.
+func (s *Serial) writeDeadlineFunc() func() time.Time {
+	var fallback time.Time
+	if 0 < s.Timeout {
+		fallback = time.Now().Add(s.Timeout)
+	}
+
+	return func() time.Time {
+		if d := s.getWriteDeadline(); !d.IsZero() {
+			return d
+		}
+		return fallback
+	}
+}
+
+// writeTimed writes the bytes in chunks, waiting for room in the output
+// buffer before each one until the deadline.
+func (s *Serial) writeTimed(b []byte, deadline func() time.Time) (n int, err error) {
+	for n < len(b) {
+		if d := deadline(); !d.IsZero() && time.Now().After(d) {
+			return n, os.ErrDeadlineExceeded
+		}
+
+		ready, err := s.pollUntil(unix.POLLOUT, deadline)
+		if nil != err {
+			return n, err
+		}
+		if !ready {
+			return n, os.ErrDeadlineExceeded
+		}
+
+		end := n + writeChunk
+		if len(b) < end {
+			end = len(b)
+		}
+
+		w, err := s.file.Write(b[n:end])
+		n += w
+		if nil != err {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// ReadChunk returns as soon as any input is available, with up to len(b)
+// bytes, without depending on the Vmin and Vtime settings, which suits
+// interactive proxying.  It waits up to timeout for the input to arrive
+// (zero uses Timeout, and waits forever if that is zero too) and then does
+// a single read of whatever is waiting.  If nothing arrives before the
+// timeout os.ErrDeadlineExceeded is returned.
+func (s *Serial) ReadChunk(b []byte, timeout time.Duration) (int, error) {
+	if nil == s.file {
+		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if 0 == len(b) {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	if buf := s.buffered(); 0 < len(buf) {
+		n := copy(b, buf)
+		s.rbuf = buf[n:]
+		s.mu.Unlock()
+
+		return n, nil
+	}
+	s.mu.Unlock()
+
+	ready, err := s.poll(unix.POLLIN, s.deadlineFor(timeout))
+	if nil != err {
+		return 0, err
+	}
+	if !ready {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	return s.readAvailable(b)
+}
+
+// ReadFrame reads a burst of data that is delimited by silence on the line.
+// It waits up to timeout for the first byte to arrive (zero uses Timeout),
+// then reads until no more bytes arrive for the idle duration.  If no data
+// arrives before the timeout os.ErrDeadlineExceeded is returned.
+func (s *Serial) ReadFrame(idle, timeout time.Duration) ([]byte, error) {
+	if nil == s.file {
+		return nil, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	deadline := s.deadlineFor(timeout)
+	for {
+		s.mu.Lock()
+		if 0 < len(s.buffered()) {
+			deadline = time.Now().Add(idle)
+		}
+		s.mu.Unlock()
+
+		err := s.fill(deadline)
+
+		s.mu.Lock()
+		if os.ErrDeadlineExceeded == err && 0 < len(s.rbuf) {
+			err = nil
+		} else if nil == err {
+			s.mu.Unlock()
+			continue
+		}
+
+		frame := s.rbuf
+		s.rbuf = nil
+		s.mu.Unlock()
+		if 0 == len(frame) {
+			frame = nil
+		}
+
+		return frame, err
+	}
+}
+
+// ReadLineAuto reads a line terminated by CR, LF or CRLF and returns it
+// without the terminator.  It waits up to timeout for the whole line (zero
+// uses Timeout).  On timeout os.ErrDeadlineExceeded is returned and any
+// partial line is kept for the next call.
+func (s *Serial) ReadLineAuto(timeout time.Duration) (string, error) {
+	if nil == s.file {
+		return "", fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	deadline := s.deadlineFor(timeout)
+	for {
+		s.mu.Lock()
+		buf := s.buffered()
+		if i := bytes.IndexAny(buf, "\r\n"); 0 <= i {
+			line := string(buf[:i])
+			s.skipLF = '\r' == buf[i]
+			s.rbuf = buf[i+1:]
+			s.mu.Unlock()
+
+			return line, nil
+		}
+		s.mu.Unlock()
+
+		if err := s.fill(deadline); nil != err {
+			return "", err
+		}
+	}
+}
+
+// ReadLines collects lines, as ReadLineAuto reads them, until maxLines
+// have been read or no complete line arrives within the idle duration (zero
+// uses Timeout), for multi-line output such as a banner or a table.  Running
+// out of lines is not an error: the lines read so far are returned, and a
+// partial line is kept for the next call.
+func (s *Serial) ReadLines(maxLines int, idle time.Duration) ([]string, error) {
+	if maxLines < 1 {
+		return nil, fmt.Errorf("Invalid number of lines: %d", maxLines)
+	}
+
+	var lines []string
+	for len(lines) < maxLines {
+		line, err := s.ReadLineAuto(idle)
+		if os.ErrDeadlineExceeded == err {
+			break
+		}
+		if nil != err {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+// ReadMarked reads the input received with ParityMarked or BreakMarked and
+// decodes the PARMRK escapes: it returns the data bytes along with a flag
+// for each one that is set if the byte was received with a parity or
+// framing error, or is the NUL reported for a break.  It waits up to
+// timeout for input (zero uses Timeout), returning os.ErrDeadlineExceeded
+// if none arrives.  An escape split across reads is kept for the next call.
+//
+// With BreakMarked a break ends the read: the data received before it is
+// returned along with ErrBreakReceived, so the caller can resynchronize on
+// it.  Since a break is marked like a NUL with a framing error, such a NUL
+// is reported as a break too.
+func (s *Serial) ReadMarked(timeout time.Duration) (data []byte, errs []bool, err error) {
+	if nil == s.file {
+		return nil, nil, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if ParityMarked != s.ParityChecking && BreakMarked != s.Break {
+		return nil, nil, fmt.Errorf("Serial port '%s' does not mark input errors.", s.Name)
+	}
+
+	deadline := s.deadlineFor(timeout)
+	for {
+		s.mu.Lock()
+		buf := s.buffered()
+		i, brk := 0, false
+		for i < len(buf) {
+			if 0xff != buf[i] {
+				data = append(data, buf[i])
+				errs = append(errs, false)
+				i++
+				continue
+			}
+			if len(buf) < i+2 {
+				break
+			}
+			if 0x00 != buf[i+1] {
+				data = append(data, 0xff)
+				errs = append(errs, false)
+				i += 2
+				continue
+			}
+			if len(buf) < i+3 {
+				break
+			}
+			if BreakMarked == s.Break && 0x00 == buf[i+2] {
+				i += 3
+				brk = true
+				break
+			}
+			data = append(data, buf[i+2])
+			errs = append(errs, true)
+			i += 3
+		}
+		s.rbuf = buf[i:]
+		s.mu.Unlock()
+
+		if brk {
+			return data, errs, ErrBreakReceived
+		}
+		if 0 < len(data) {
+			return data, errs, nil
+		}
+
+		if err := s.fill(deadline); nil != err {
+			return nil, nil, err
+		}
+	}
+}
+
+var _ io.RuneReader = (*Serial)(nil)
+
+// ReadRune reads a single UTF-8 encoded character and returns it with its
+// size in bytes, so the port can be used with text scanners that expect an
+// io.RuneReader.  A character split across reads is kept until the rest
+// arrives.  Invalid input is returned as utf8.RuneError with a size of 1,
+// like the standard library.  It waits up to Timeout, forever if that is
+// zero, returning os.ErrDeadlineExceeded if the character doesn't arrive.
+func (s *Serial) ReadRune() (r rune, size int, err error) {
+	if nil == s.file {
+		return 0, 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	deadline := s.deadlineFor(0)
+	for {
+		s.mu.Lock()
+		buf := s.buffered()
+		if utf8.FullRune(buf) {
+			r, size = utf8.DecodeRune(buf)
+			s.rbuf = buf[size:]
+			s.mu.Unlock()
+
+			return r, size, nil
+		}
+		s.mu.Unlock()
+
+		if err := s.fill(deadline); nil != err {
+			return 0, 0, err
+		}
+	}
+}
+
+// ReadUntil reads until the pattern appears in the input and returns all of
+// the data up to and including it.  It waits up to timeout for the pattern
+// (zero uses Timeout).  On timeout os.ErrDeadlineExceeded is returned and
+// the data read so far is kept for the next call, so a pattern split across
+// reads is still found.
+func (s *Serial) ReadUntil(pattern []byte, timeout time.Duration) ([]byte, error) {
+	if nil == s.file {
+		return nil, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if 0 == len(pattern) {
+		return nil, fmt.Errorf("Invalid empty pattern.")
+	}
+
+	deadline := s.deadlineFor(timeout)
+	for {
+		s.mu.Lock()
+		buf := s.buffered()
+		if i := bytes.Index(buf, pattern); 0 <= i {
+			end := i + len(pattern)
+			s.rbuf = buf[end:]
+			s.mu.Unlock()
+
+			return buf[:end:end], nil
+		}
+		s.mu.Unlock()
+
+		if err := s.fill(deadline); nil != err {
+			return nil, err
+		}
+	}
+}
+
+// ReadRecord reads exactly size bytes, waiting up to timeout for all of them
+// (zero uses Timeout).  If the timeout passes first, the bytes received so
+// far are returned along with os.ErrDeadlineExceeded.
+func (s *Serial) ReadRecord(size int, timeout time.Duration) ([]byte, error) {
+	if nil == s.file {
+		return nil, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("Invalid record size: %d", size)
+	}
+
+	deadline := s.deadlineFor(timeout)
+	for {
+		s.mu.Lock()
+		buf := s.buffered()
+		if size <= len(buf) {
+			s.rbuf = buf[size:]
+			s.mu.Unlock()
+
+			return buf[:size:size], nil
+		}
+		s.mu.Unlock()
+
+		if err := s.fill(deadline); nil != err {
+			s.mu.Lock()
+			buf = s.rbuf
+			s.rbuf = nil
+			s.mu.Unlock()
+			if 0 == len(buf) {
+				buf = nil
+			}
+
+			return buf, err
+		}
+	}
+}
+
+// ReadBudget reads up to limit bytes, returning early once the line has
+// been idle for the idle duration, for variable length responses with a
+// known upper bound and a gap at the end.  It waits for the first byte for
+// up to Timeout, forever if that is zero, and returns
+// os.ErrDeadlineExceeded if nothing arrives.  Input beyond limit is kept
+// for the next call.
+func (s *Serial) ReadBudget(limit int, idle time.Duration) ([]byte, error) {
+	if nil == s.file {
+		return nil, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if limit < 1 {
+		return nil, fmt.Errorf("Invalid read budget: %d", limit)
+	}
+
+	deadline := s.deadlineFor(0)
+	for {
+		s.mu.Lock()
+		buf := s.buffered()
+		if limit <= len(buf) {
+			s.rbuf = buf[limit:]
+			s.mu.Unlock()
+
+			return buf[:limit:limit], nil
+		}
+		if 0 < len(buf) {
+			deadline = time.Now().Add(idle)
+		}
+		s.mu.Unlock()
+
+		err := s.fill(deadline)
+		if nil == err {
+			continue
+		}
+
+		s.mu.Lock()
+		if os.ErrDeadlineExceeded == err && 0 < len(s.rbuf) {
+			err = nil
+		}
+		buf = s.rbuf
+		s.rbuf = nil
+		s.mu.Unlock()
+		if 0 == len(buf) {
+			buf = nil
+		}
+
+		return buf, err
+	}
+}
+
+// WriteAndDrain writes all of the bytes and waits until they have been
+// transmitted, so a response timeout can be started once the command is
+// on the wire.  If a write deadline or a default Timeout is set it bounds
+// the wait as well, which returns os.ErrDeadlineExceeded if the output
+// doesn't drain in time.
+func (s *Serial) WriteAndDrain(b []byte) (int, error) {
+	deadline := s.currentWriteDeadline()
+
+	n, err := s.Write(b)
+	if nil != err {
+		return n, err
+	}
+
+	if !deadline.IsZero() {
+		if err := s.drainUntil(deadline); nil != err {
+			return n, err
+		}
+	}
+
+	return n, s.Drain()
+}
+
+// drainUntil waits until the output queue is empty or the deadline passes.
+func (s *Serial) drainUntil(deadline time.Time) error {
+	return s.waitOutputBelow(1, deadline)
+}
+
+// waitOutputBelow waits until fewer than limit bytes are waiting to be
+// transmitted or the deadline passes.  A zero deadline waits forever.
+func (s *Serial) waitOutputBelow(limit int, deadline time.Time) error {
+	for {
+		waiting, err := s.OutputWaiting()
+		if nil != err {
+			return err
+		}
+		if waiting < limit {
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return os.ErrDeadlineExceeded
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// WaitOutputBelow waits until fewer than bytes are waiting to be
+// transmitted, so more data can be queued at a steady pace without waiting
+// for the output to drain completely.  It checks the output queue
+// (TIOCOUTQ) every millisecond for up to timeout (zero uses Timeout, and
+// waits forever if that is zero too), returning os.ErrDeadlineExceeded if
+// the queue doesn't shrink in time.
+func (s *Serial) WaitOutputBelow(bytes int, timeout time.Duration) error {
+	if bytes < 1 {
+		return fmt.Errorf("Invalid output watermark: %d", bytes)
+	}
+
+	return s.waitOutputBelow(bytes, s.deadlineFor(timeout))
+}
+
+// DrainTimeout waits up to timeout for all of the output written to the
+// serial port to be transmitted, returning os.ErrDeadlineExceeded if it
+// isn't, for example because flow control is holding it off.  A zero
+// timeout waits forever like Drain.
+func (s *Serial) DrainTimeout(timeout time.Duration) error {
+	if 0 < timeout {
+		if err := s.drainUntil(time.Now().Add(timeout)); nil != err {
+			return err
+		}
+	}
+
+	return s.Drain()
+}
+
+// Shutdown closes the serial port cleanly: it stops new writes, waits up to
+// timeout for pending output to be transmitted, discards any unread input
+// and closes the port.  Every step is performed even if an earlier one
+// fails, and the first error is returned.  OnClose is called once writes
+// are allowed again, so it can use the port and get a "not open" error
+// instead of blocking.
+func (s *Serial) Shutdown(timeout time.Duration) error {
+	s.wmu.Lock()
+
+	err := s.DrainTimeout(timeout)
+	if ferr := s.FlushInput(); nil == err {
+		err = ferr
+	}
+	open, cerr := s.closeFile()
+
+	s.wmu.Unlock()
+
+	if open {
+		s.closed(cerr)
+	}
+
+	return err
+}
+
+// ReadLengthPrefixed reads a frame made of a length prefix of prefixBytes
+// bytes (1, 2 or 4) in the given byte order, followed by that many bytes of
+// payload, and returns the payload.  The timeout covers the whole frame
+// (zero uses Timeout); on timeout os.ErrDeadlineExceeded is returned and the
+// partial frame is kept for the next call.  A length above MaxPayload is
+// treated as corrupt input: the prefix is dropped and an error returned.
+func (s *Serial) ReadLengthPrefixed(prefixBytes int, order binary.ByteOrder, timeout time.Duration) ([]byte, error) {
+	if nil == s.file {
+		return nil, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if 1 != prefixBytes && 2 != prefixBytes && 4 != prefixBytes {
+		return nil, fmt.Errorf("Invalid length prefix size: %d", prefixBytes)
+	}
+	if nil == order && 1 < prefixBytes {
+		return nil, fmt.Errorf("Invalid nil byte order.")
+	}
+
+	limit := uint64(s.MaxPayload)
+	if 0 == limit {
+		limit = DefaultMaxPayload
+	}
+
+	deadline := s.deadlineFor(timeout)
+	for {
+		s.mu.Lock()
+		buf := s.buffered()
+		if prefixBytes <= len(buf) {
+			var length uint64
+			switch prefixBytes {
+			case 1:
+				length = uint64(buf[0])
+			case 2:
+				length = uint64(order.Uint16(buf))
+			case 4:
+				length = uint64(order.Uint32(buf))
+			}
+
+			if limit < length {
+				s.rbuf = buf[prefixBytes:]
+				s.mu.Unlock()
+				return nil, fmt.Errorf("Length prefix %d exceeds the maximum payload of %d bytes.", length, limit)
+			}
+
+			if end := prefixBytes + int(length); end <= len(buf) {
+				s.rbuf = buf[end:]
+				s.mu.Unlock()
+				return buf[prefixBytes:end:end], nil
+			}
+		}
+		s.mu.Unlock()
+
+		if err := s.fill(deadline); nil != err {
+			return nil, err
+		}
+	}
+}
+
+// TimedByte is one entry of a WriteTimed schedule: the payload to send and
+// when to send it, as an offset from the start of the schedule.
+type TimedByte struct {
+	Offset  time.Duration
+	Payload []byte
+}
+
+// WriteTimed sends each payload once its offset from the start of the call
+// has passed, for replaying captured traffic or reproducing bus timing.
+// The schedule is measured on the monotonic clock so drift doesn't build
+// up, and a payload that is already late is sent right away.  The offset is
+// when the payload is handed to the driver, not when it is on the wire, and
+// the scheduler typically adds tens of microseconds to a millisecond or
+// more of jitter on top of the UART's own queueing.
+func (s *Serial) WriteTimed(events []TimedByte) error {
+	start := time.Now()
+
+	for _, e := range events {
+		if d := time.Until(start.Add(e.Offset)); 0 < d {
+			time.Sleep(d)
+		}
+
+		if _, err := s.Write(e.Payload); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AutoBaud finds the baud rate of a device that sends data on its own, such
+// as a GPS receiver sending NMEA sentences.  For each candidate in turn it
+// applies the rate, discards the pending input, collects what arrives
+// within timeout and passes it to probe, which reports if the data looks
+// valid.  The first rate the probe accepts is returned and left applied.
+// On any error, including none being accepted, the original rate is
+// restored.
+func (s *Serial) AutoBaud(candidates []int, probe func([]byte) bool, timeout time.Duration) (_ int, err error) {
+	if nil == s.file {
+		return 0, fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("Invalid auto baud timeout: %s", timeout)
+	}
+
+	orig := s.Baud
+	defer func() {
+		if nil != err {
+			s.Baud = orig
+			if rerr := s.UpdateCfg(); nil != rerr {
+				err = errors.Join(err, rerr)
+			}
+		}
+	}()
+
+	for _, baud := range candidates {
+		s.Baud = baud
+		if err := s.UpdateCfg(); nil != err {
+			return 0, err
+		}
+		if err := s.FlushInput(); nil != err {
+			return 0, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		for {
+			err := s.fill(deadline)
+			if os.ErrDeadlineExceeded == err {
+				break
+			}
+			if nil != err {
+				return 0, err
+			}
+		}
+
+		s.mu.Lock()
+		data := s.rbuf
+		s.rbuf = nil
+		s.mu.Unlock()
+
+		if probe(data) {
+			return baud, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Serial port '%s' did not match any of the %d baud rates.", s.Name, len(candidates))
+}
+
+// WaitBusIdle waits until nothing has been received for the idle duration,
+// so a node on a shared RS-485 bus can transmit without colliding with
+// another one.  It waits up to timeout for the silence (zero uses Timeout,
+// and waits forever if that is zero too) and returns
+// os.ErrDeadlineExceeded if the bus stays busy.  The input received while
+// waiting is kept for the next read.
+func (s *Serial) WaitBusIdle(idle, timeout time.Duration) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+
+	deadline := s.deadlineFor(timeout)
+	for {
+		quiet := time.Now().Add(idle)
+		wait, busy := quiet, false
+		if !deadline.IsZero() && deadline.Before(quiet) {
+			wait, busy = deadline, true
+		}
+
+		err := s.fill(wait)
+		if os.ErrDeadlineExceeded == err && !busy {
+			return nil
+		}
+		if nil != err {
+			return err
+		}
+	}
+}
+
+// probeFramings are the framings ProbeFraming tries.  The 7 bit framings
+// come first since 8N1 also receives 7E1 and 7O1 traffic without errors,
+// and ties go to the earlier framing.
+var probeFramings = []string{"7E1", "7O1", "8E1", "8O1", "8N1"}
+
+// FramingStats is what ProbeFraming observed with one framing.
+type FramingStats struct {
+	Framing string // The configuration string, such as '8N1'
+	Bytes   int    // Number of bytes received
+	Errors  int    // Number of bytes received with parity or framing errors
+}
+
+// ProbeFraming guesses the data bits and parity of a device that sends
+// data on its own, once the baud rate is known, for example with AutoBaud.
+// For each of 7E1, 7O1, 8E1, 8O1 and 8N1 it collects up to samples bytes
+// within timeout with parity checking on and counts the parity and framing
+// errors.  The framing with the lowest error rate is returned as the
+// current configuration with that framing, along with the stats for every
+// framing.  The port is left configured as it was; if restoring the
+// configuration fails that error is joined into the one returned.
+func (s *Serial) ProbeFraming(samples int, timeout time.Duration) (_ Config, _ []FramingStats, err error) {
+	if samples < 1 {
+		return Config{}, nil, fmt.Errorf("Invalid number of samples: %d", samples)
+	}
+	if timeout <= 0 {
+		return Config{}, nil, fmt.Errorf("Invalid probe timeout: %s", timeout)
+	}
+
+	orig := s.GetConfig()
+	defer func() {
+		if rerr := s.Configure(orig); nil != rerr {
+			err = errors.Join(err, rerr)
+		}
+	}()
+
+	stats := make([]FramingStats, 0, len(probeFramings))
+	best := -1
+	for _, framing := range probeFramings {
+		c := orig
+		c.Framing = framing
+		c.ParityChecking = ParityMarked
+		if err := s.Configure(c); nil != err {
+			return Config{}, stats, err
+		}
+		if err := s.FlushInput(); nil != err {
+			return Config{}, stats, err
+		}
+
+		st := FramingStats{Framing: framing}
+		deadline := time.Now().Add(timeout)
+		for st.Bytes < samples {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				break
+			}
+			data, errs, err := s.ReadMarked(remaining)
+			if os.ErrDeadlineExceeded == err {
+				break
+			}
+			if nil != err && ErrBreakReceived != err {
+				return Config{}, stats, err
+			}
+			st.Bytes += len(data)
+			for _, e := range errs {
+				if e {
+					st.Errors++
+				}
+			}
+		}
+		stats = append(stats, st)
+
+		// Compare the error rates without dividing: a/b < c/d.
+		if 0 < st.Bytes && (best < 0 || st.Errors*stats[best].Bytes < stats[best].Errors*st.Bytes) {
+			best = len(stats) - 1
+		}
+	}
+
+	if best < 0 {
+		return Config{}, stats, fmt.Errorf("Serial port '%s' received no data to probe the framing.", s.Name)
+	}
+
+	c := orig
+	c.Framing = stats[best].Framing
+
+	return c, stats, nil
+}
+
+// selfTestSize is the size of the pattern SelfTest sends, every byte value
+// once.
+const selfTestSize = 256
+
+// SelfTestResult is the outcome of SelfTest.
+type SelfTestResult struct {
+	Looped    bool          // Anything was echoed back
+	Sent      int           // Number of bytes sent
+	Received  int           // Number of bytes received back
+	Errors    int           // Number of bytes received back wrong or not at all
+	ErrorRate float64       // Errors as a fraction of Sent
+	Latency   time.Duration // Time from the write until the first byte came back
+	RoundTrip time.Duration // Time from the write until the last byte came back
+}
+
+// SelfTest checks the port's health by sending every byte value once at
+// the configured settings and comparing what comes back.  The full test
+// needs a loopback: TX wired to RX (pins 2 and 3 of a DB9 connector) on
+// the port or adapter.  Without one nothing is echoed and the result
+// reports Looped as false with every byte counted as an error.  The port
+// should be in raw mode, since translations such as Terminal change the
+// pattern, and any pending input is discarded first.
+func (s *Serial) SelfTest() (SelfTestResult, error) {
+	var r SelfTestResult
+
+	d, err := s.ByteDuration()
+	if nil != err {
+		return r, err
+	}
+	timeout := 2*selfTestSize*d + 100*time.Millisecond
+
+	if err := s.FlushInput(); nil != err {
+		return r, err
+	}
+
+	pattern := make([]byte, selfTestSize)
+	for i := range pattern {
+		pattern[i] = byte(i)
+	}
+
+	start := time.Now()
+	n, err := s.Write(pattern)
+	r.Sent = n
+	if nil != err {
+		return r, err
+	}
+
+	first, err := s.ReadRecord(1, timeout)
+	if nil != err && os.ErrDeadlineExceeded != err {
+		return r, err
+	}
+	r.Latency = time.Since(start)
+
+	var echo []byte
+	if 0 < len(first) {
+		r.Looped = true
+		rest, err := s.ReadRecord(r.Sent-1, timeout)
+		if nil != err && os.ErrDeadlineExceeded != err {
+			return r, err
+		}
+		r.RoundTrip = time.Since(start)
+		echo = append(first, rest...)
+	} else {
+		r.Latency = 0
+	}
+
+	r.Received = len(echo)
+	r.Errors = r.Sent - r.Received
+	for i, b := range echo {
+		if pattern[i] != b {
+			r.Errors++
+		}
+	}
+	r.ErrorRate = float64(r.Errors) / float64(r.Sent)
+
+	return r, nil
+}
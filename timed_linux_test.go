@@ -0,0 +1,433 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pendingRead starts a Read on the port and returns a channel receiving
+// its error, once the Read has had time to start waiting.
+func pendingRead(s *Serial) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	return done
+}
+
+// waitErr waits for the error of a pending operation started in the
+// background, failing the test if it doesn't finish within testTimeout.
+func waitErr(t *testing.T, done <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(testTimeout):
+		t.Fatalf("the pending operation was not woken")
+	}
+
+	return nil
+}
+
+func TestNetConn(t *testing.T) {
+	var port Serial
+	openPair(t, &port)
+
+	var c net.Conn = &port
+	if port.Name != c.LocalAddr().String() || port.Name != c.RemoteAddr().String() {
+		t.Fatalf("addresses are %q and %q, expected %q", c.LocalAddr(), c.RemoteAddr(), port.Name)
+	}
+}
+
+func TestSetReadDeadlineWakesRead(t *testing.T) {
+	tests := []struct {
+		description string
+		vmin        byte
+		waitForData bool
+	}{
+		{description: "waiting for a deadline"},
+		{description: "blocking for Vmin", vmin: 1},
+		{description: "waiting for data", waitForData: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			port := Serial{Vmin: tc.vmin, WaitForData: tc.waitForData}
+			openPair(t, &port)
+
+			port.SetReadDeadline(time.Now().Add(time.Hour))
+			done := pendingRead(&port)
+			port.SetReadDeadline(time.Now())
+
+			if err := waitErr(t, done); !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("Read() = %v, expected os.ErrDeadlineExceeded", err)
+			}
+		})
+	}
+}
+
+func TestSetReadDeadlineExtends(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	port.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	done := pendingRead(&port)
+	port.SetReadDeadline(time.Time{})
+
+	// The Read outlives its original deadline and gets the data.
+	time.Sleep(200 * time.Millisecond)
+	writeAllTo(t, master, []byte{'x'})
+	if err := waitErr(t, done); nil != err {
+		t.Fatalf("Read() error: %v", err)
+	}
+}
+
+func TestCloseWakesRead(t *testing.T) {
+	tests := []struct {
+		description string
+		vmin        byte
+		waitForData bool
+		deadline    time.Duration
+		pump        bool
+	}{
+		{description: "waiting for a deadline", deadline: time.Hour},
+		{description: "blocking for Vmin", vmin: 1},
+		{description: "waiting for data", waitForData: true},
+		{description: "waiting for the pump", pump: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			port := Serial{Vmin: tc.vmin, WaitForData: tc.waitForData}
+			openPair(t, &port)
+
+			if 0 < tc.deadline {
+				port.SetReadDeadline(time.Now().Add(tc.deadline))
+			}
+			if tc.pump {
+				if err := port.StartPump(64); nil != err {
+					t.Fatalf("StartPump() error: %v", err)
+				}
+			}
+
+			done := pendingRead(&port)
+			port.Close()
+
+			if err := waitErr(t, done); !errors.Is(err, os.ErrClosed) {
+				t.Fatalf("Read() = %v, expected os.ErrClosed", err)
+			}
+		})
+	}
+}
+
+func TestSetWriteDeadlineWakesWrite(t *testing.T) {
+	var port Serial
+	openPair(t, &port)
+
+	// Nobody reads the master, so the output fills up and Write waits.
+	port.SetWriteDeadline(time.Now().Add(time.Hour))
+	done := make(chan error, 1)
+	go func() {
+		_, err := port.Write(make([]byte, 1<<20))
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	port.SetWriteDeadline(time.Now())
+
+	if err := waitErr(t, done); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write() = %v, expected os.ErrDeadlineExceeded", err)
+	}
+}
+
+func TestDeadlinesConcurrently(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			port.SetDeadline(time.Now().Add(time.Millisecond))
+		}
+	}()
+
+	go master.Write(make([]byte, 100))
+	for i := 0; i < 100; i++ {
+		// Any outcome is fine, the race detector checks the access.
+		port.Read(make([]byte, 10))
+	}
+}
+
+func TestShutdownOnCloseWrite(t *testing.T) {
+	port := Serial{}
+	openPair(t, &port)
+
+	called := make(chan error, 1)
+	port.OnClose = func(error) {
+		_, err := port.Write([]byte("late"))
+		called <- err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- port.Shutdown(testTimeout) }()
+	if err := waitErr(t, done); nil != err {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+
+	select {
+	case err := <-called:
+		if nil == err {
+			t.Fatalf("Write() from OnClose succeeded on a closed port")
+		}
+	default:
+		t.Fatalf("OnClose wasn't called")
+	}
+}
+
+func TestAutoBaudRestores(t *testing.T) {
+	tests := []struct {
+		description string
+		candidates  []int
+	}{
+		{description: "no match", candidates: []int{19200, 38400}},
+		{description: "invalid candidate", candidates: []int{19200, -1}},
+	}
+
+	var port Serial
+	openPair(t, &port)
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			reject := func([]byte) bool { return false }
+			if _, err := port.AutoBaud(tc.candidates, reject, 10*time.Millisecond); nil == err {
+				t.Fatalf("AutoBaud() succeeded")
+			}
+
+			if 9600 != port.Baud {
+				t.Errorf("Baud = %d after AutoBaud(), expected 9600", port.Baud)
+			}
+			_, _, output, err := port.EffectiveBaud()
+			if nil != err || 9600 != output {
+				t.Errorf("EffectiveBaud() = %d, %v, expected 9600", output, err)
+			}
+		})
+	}
+}
+
+func TestProbeFramingRestores(t *testing.T) {
+	var port Serial
+	master := openPair(t, &port)
+
+	before, err := port.GetTermios()
+	if nil != err {
+		t.Fatalf("GetTermios() error: %v", err)
+	}
+
+	// Without any traffic the probe fails, but still restores the port.
+	if _, _, err := port.ProbeFraming(16, 10*time.Millisecond); nil == err {
+		t.Fatalf("ProbeFraming() without data succeeded")
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			master.Write([]byte("UUUU"))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	c, stats, err := port.ProbeFraming(16, 200*time.Millisecond)
+	close(stop)
+	<-done
+	if nil != err {
+		t.Fatalf("ProbeFraming() error: %v", err)
+	}
+	if len(probeFramings) != len(stats) || "" == c.Framing {
+		t.Fatalf("ProbeFraming() = %+v, %+v", c, stats)
+	}
+
+	after, err := port.GetTermios()
+	if nil != err {
+		t.Fatalf("GetTermios() error: %v", err)
+	}
+	if before != after {
+		t.Fatalf("termios after ProbeFraming() = %+v, expected %+v", after, before)
+	}
+	if "" != port.Config || ParityUnchecked != port.ParityChecking {
+		t.Fatalf("ProbeFraming() left Config %q, ParityChecking %d", port.Config, port.ParityChecking)
+	}
+}
+
+// fakeLatencyTimer makes the port look like a USB adapter with the given
+// latency_timer in sysfs for the rest of the test.
+func fakeLatencyTimer(t *testing.T, s *Serial, ms string) {
+	t.Helper()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, filepath.Base(s.Name), "device")
+	if err := os.MkdirAll(dir, 0755); nil != err {
+		t.Fatalf("MkdirAll() error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "latency_timer"), []byte(ms+"\n"), 0644); nil != err {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	orig := sysClassTTY
+	sysClassTTY = root
+	t.Cleanup(func() { sysClassTTY = orig })
+}
+
+func TestCheckReadTimeout(t *testing.T) {
+	var port Serial
+	openPair(t, &port)
+	fakeLatencyTimer(t, &port, "16")
+
+	if err := port.CheckReadTimeout(5 * time.Millisecond); !errors.Is(err, ErrTimeoutBelowLatency) {
+		t.Fatalf("CheckReadTimeout(5ms) = %v, expected ErrTimeoutBelowLatency", err)
+	}
+	if err := port.CheckReadTimeout(50 * time.Millisecond); nil != err {
+		t.Fatalf("CheckReadTimeout(50ms) error: %v", err)
+	}
+
+	// The short timeout is applied anyway.
+	if err := port.SetReadTimeout(5 * time.Millisecond); nil != err {
+		t.Fatalf("SetReadTimeout(5ms) error: %v", err)
+	}
+}
+
+func TestCheckReadTimeoutUnbuffered(t *testing.T) {
+	var port Serial
+	openPair(t, &port)
+	fakeLatencyTimer(t, &port, "0")
+
+	if err := port.CheckReadTimeout(time.Millisecond); nil != err {
+		t.Fatalf("CheckReadTimeout(1ms) on a port without buffering error: %v", err)
+	}
+}
+
+func TestReadTimeoutLatencyWarning(t *testing.T) {
+	var logs bytes.Buffer
+	port := Serial{Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+	openPair(t, &port)
+	fakeLatencyTimer(t, &port, "16")
+
+	if err := port.SetReadTimeout(50 * time.Millisecond); nil != err {
+		t.Fatalf("SetReadTimeout(50ms) error: %v", err)
+	}
+	if strings.Contains(logs.String(), "shorter than its latency") {
+		t.Fatalf("SetReadTimeout(50ms) logged a latency warning: %s", logs.String())
+	}
+
+	if err := port.SetReadTimeout(5 * time.Millisecond); nil != err {
+		t.Fatalf("SetReadTimeout(5ms) error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "level=WARN msg=\"serial port read timeout is shorter than its latency\"") {
+		t.Fatalf("SetReadTimeout(5ms) didn't log a latency warning: %s", logs.String())
+	}
+}
+
+var timeoutModes = []struct {
+	description string
+	mode        TimeoutMode
+}{
+	{description: "poll", mode: TimeoutPoll},
+	{description: "vtime", mode: TimeoutVTIME},
+}
+
+// openTimed opens a pty pair with the read timeout handled in the mode.
+func openTimed(b *testing.B, port *Serial, mode TimeoutMode, timeout time.Duration) *Serial {
+	b.Helper()
+
+	master := openPair(b, port)
+	if err := port.SetTimeoutMode(mode); nil != err {
+		b.Fatalf("SetTimeoutMode() error: %v", err)
+	}
+	if err := port.SetReadTimeout(timeout); nil != err {
+		b.Fatalf("SetReadTimeout() error: %v", err)
+	}
+
+	return master
+}
+
+// BenchmarkReadTimeout measures the cost of a read with a timeout when the
+// data arrives, which is the overhead the poll based timeout adds.
+func BenchmarkReadTimeout(b *testing.B) {
+	for _, tc := range timeoutModes {
+		b.Run(tc.description, func(b *testing.B) {
+			var port Serial
+			master := openTimed(b, &port, tc.mode, 100*time.Millisecond)
+
+			buf := make([]byte, 64)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				writeAllTo(b, master, []byte{byte(i)})
+				if n, err := port.Read(buf); 1 != n || nil != err {
+					b.Fatalf("Read() = %d, %v", n, err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReadTimeoutExpiry measures how long a read waits when no data
+// arrives, with the shortest timeout each mode supports.
+func BenchmarkReadTimeoutExpiry(b *testing.B) {
+	timeouts := map[TimeoutMode]time.Duration{
+		TimeoutPoll:  5 * time.Millisecond,
+		TimeoutVTIME: 100 * time.Millisecond,
+	}
+
+	for _, tc := range timeoutModes {
+		b.Run(tc.description, func(b *testing.B) {
+			var port Serial
+			openTimed(b, &port, tc.mode, timeouts[tc.mode])
+
+			buf := make([]byte, 64)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if n, err := port.Read(buf); 0 != n || nil != err {
+					b.Fatalf("Read() = %d, %v", n, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// usbdevfsReset is USBDEVFS_RESET, _IO('U', 20).  The direction bits of
+// _IO differ between architectures, so they are taken from BLKRRPART,
+// which is _IO(0x12, 95).
+const usbdevfsReset = unix.BLKRRPART&^0xffff | 'U'<<8 | 20
+
+const (
+	// usbOpenRetryDelay is the pause between the attempts to open the port
+	// in OpenWithUSBReset.
+	usbOpenRetryDelay = 100 * time.Millisecond
+
+	// usbResetSettle is how long OpenWithUSBReset waits after a reset for
+	// the adapter to be enumerated again.
+	usbResetSettle = 2 * time.Second
+)
+
+// ResetUSB resets the USB device behind the named serial port
+// (USBDEVFS_RESET), which makes it re-enumerate as if it had been
+// unplugged and plugged in again.  It needs write access to the device's
+// node in /dev/bus/usb, which usually means root or a udev rule.  Non-USB
+// ports return ErrNotSupported.
+func ResetUSB(name string) error {
+	bus, err := usbAttr(name, "busnum")
+	if nil != err {
+		return err
+	}
+	dev, err := usbAttr(name, "devnum")
+	if nil != err {
+		return err
+	}
+
+	b, err := strconv.Atoi(bus)
+	if nil != err {
+		return fmt.Errorf("Invalid USB bus number '%s' for '%s'.", bus, name)
+	}
+	d, err := strconv.Atoi(dev)
+	if nil != err {
+		return fmt.Errorf("Invalid USB device number '%s' for '%s'.", dev, name)
+	}
+
+	f, err := os.OpenFile(fmt.Sprintf("/dev/bus/usb/%03d/%03d", b, d), unix.O_WRONLY, 0)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+
+	var arg int32
+	errno := ioctl(f.Fd(), usbdevfsReset, uintptr(unsafe.Pointer(&arg)))
+	if 0 != errno {
+		return fmt.Errorf("ioctl( '%s', USBDEVFS_RESET ) error: %w", f.Name(), errno)
+	}
+
+	return nil
+}
+
+// OpenWithUSBReset opens the port like Open, trying up to attempts times.
+// If every attempt fails, the USB adapter behind the port is reset with
+// ResetUSB, which needs write access to its node in /dev/bus/usb, and
+// after giving it time to be enumerated again the open is tried up to
+// attempts times more.  This recovers a stuck adapter without unplugging
+// it, at the cost of disturbing anything else using the adapter.
+func (s *Serial) OpenWithUSBReset(attempts int) error {
+	if nil != s.file {
+		return fmt.Errorf("Serial port '%s' already open.", s.Name)
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	err := s.openAttempts(attempts)
+	if nil == err {
+		return nil
+	}
+
+	if rerr := ResetUSB(s.Name); nil != rerr {
+		return fmt.Errorf("Serial port '%s' could not be opened or reset: %w", s.Name, errors.Join(err, rerr))
+	}
+	time.Sleep(usbResetSettle)
+
+	return s.openAttempts(attempts)
+}
+
+// openAttempts tries to open the port up to attempts times, returning the
+// last error if none succeeds.
+func (s *Serial) openAttempts(attempts int) (err error) {
+	for i := 0; i < attempts; i++ {
+		if 0 < i {
+			time.Sleep(usbOpenRetryDelay)
+		}
+		if err = s.Open(); nil == err {
+			return nil
+		}
+		s.Close()
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+/**
+ * Copyright 2019 Weston Schmidt
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package go232
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchedCflag are the Cflag bits WatchdogReapply compares.  The others
+// are left out since drivers commonly adjust them.
+const watchedCflag = unix.CBAUD | unix.CSIZE | unix.CSTOPB | unix.PARENB |
+	unix.PARODD | unix.CREAD | unix.CRTSCTS
+
+// WatchdogReapply starts checking the port's termios every interval
+// against the settings in the Serial fields, re-applying them whenever
+// they drifted, for adapters that reset their settings on their own, for
+// example when they are re-enumerated.  Each correction is logged to
+// Logger.  The watchdog runs until Close, and calling WatchdogReapply again
+// replaces it.
+func (s *Serial) WatchdogReapply(interval time.Duration) error {
+	if nil == s.file {
+		return fmt.Errorf("Serial port '%s' not open.", s.Name)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("Invalid watchdog interval: %s", interval)
+	}
+
+	stop := make(chan struct{})
+
+	s.mu.Lock()
+	if nil != s.watchdog {
+		close(s.watchdog)
+	}
+	s.watchdog = stop
+	s.mu.Unlock()
+
+	go s.runWatchdog(interval, stop)
+
+	return nil
+}
+
+func (s *Serial) runWatchdog(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		want, err := s.buildTermios()
+		if nil != err {
+			continue
+		}
+		got, err := s.GetTermios()
+		if nil != err {
+			continue
+		}
+
+		if !termiosDrifted(want, got) {
+			continue
+		}
+		s.logOp("reapply drifted termios", s.applyTermios(want))
+	}
+}
+
+// termiosDrifted reports if the settings read back from the port differ
+// from the ones that were applied.
+func termiosDrifted(want, got unix.Termios) bool {
+	return want.Iflag != got.Iflag ||
+		want.Oflag != got.Oflag ||
+		want.Lflag != got.Lflag ||
+		want.Cflag&watchedCflag != got.Cflag&watchedCflag ||
+		want.Cc != got.Cc
+}
+
+// stopWatchdog stops the watchdog started by WatchdogReapply, if any.
+func (s *Serial) stopWatchdog() {
+	s.mu.Lock()
+	if nil != s.watchdog {
+		close(s.watchdog)
+		s.watchdog = nil
+	}
+	s.mu.Unlock()
+}